@@ -11,9 +11,13 @@ package main
 
 // Expects blockartlib.go to be in the ./blockartlib/ dir, relative to
 // this art-app.go file
-import "./blockartlib"
+import (
+	"./blockartlib"
+	"./utils"
+)
 
 import (
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/hex"
 	"fmt"
@@ -71,31 +75,9 @@ func checkError(err error) {
 	}
 }
 
-// Recursively get the longest blockchain
-func getLongestBlockchain(currBlockHash string, canvas blockartlib.Canvas) []string {
-	// Add current block hash to longest chain
-	longestBlockchain := []string{}
-	longestBlockchain = append(longestBlockchain, currBlockHash)
-
-	// Iterate through children of current block if any exist,
-	// Adding the longest of them all to the longest blockchain
-	children, err := canvas.GetChildren(currBlockHash)
-	checkError(err)
-
-	longestChildBlockchain := []string{}
-	for _, child := range children {
-		childBlockchain := getLongestBlockchain(child, canvas)
-		if len(childBlockchain) > len(longestChildBlockchain) {
-			longestChildBlockchain = childBlockchain
-		}
-	}
-
-	return append(longestBlockchain, longestChildBlockchain...)
-}
-
-// Generate an HTML file, filled exclusively with 
-// HTML SVG strings from the longest blockchain in canvas
-func generateHTML(canvas blockartlib.Canvas) {
+// Generate an HTML file, filled exclusively with HTML SVG strings
+// of the shapes live on the longest blockchain
+func generateHTML(minerAddr string, privKey ecdsa.PrivateKey, settings blockartlib.CanvasSettings) {
 	// Create a blank HTML file
 	HTML, err := os.Create("./art-app-1.html")
 	checkError(err)
@@ -103,31 +85,18 @@ func generateHTML(canvas blockartlib.Canvas) {
 
 	// Append starting HTML tags
 	pre := []byte("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<!DOCTYPE html>\n<html>\n<head>\n\t<title>HTML SVG Output</title>\n</head>\n")
-	body := []byte("<body>\n\t<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"900\" height=\"900\" version=\"1.1\">\n")
+	bodyString := fmt.Sprintf("<body>\n\t<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" version=\"1.1\">\n", settings.CanvasXMax, settings.CanvasYMax)
 	HTML.Write(pre)
-	HTML.Write(body)
+	HTML.Write([]byte(bodyString))
 
-	// Get the longest blockchain
-	// Start with the genesis block and recursively add to chain
-	gHash, err := canvas.GetGenesisBlock()
+	// Get the shapes still on the canvas at the tip of the longest
+	// blockchain. Deleted shapes are left out rather than painted white.
+	ops, err := blockartlib.GetCanvasOperations(minerAddr, privKey, "")
 	checkError(err)
-	blockchain := getLongestBlockchain(gHash, canvas)
-
-	// Add the HTML SVG string of each opeartion in the blockchain
-	for _, bHash := range blockchain {
-		sHashes, err := canvas.GetShapes(bHash)
-		checkError(err)
-		for _, sHash := range sHashes {
-			HTMLSVGString, err := canvas.GetSvgString(sHash)
-			// Expect to see an InvalidShapeHashError
-			// as the first line was deleted, but art-node can
-			// never tell strictly by shapeHash
-			if err == nil {
-				HTML.Write([]byte("\t\t" + HTMLSVGString + "\n"))
-			} else {
-				break
-			}
-		}
+
+	// Add the HTML SVG string of each live shape
+	for _, HTMLSVGString := range utils.GetLiveSVGStrings(ops) {
+		HTML.Write([]byte("\t\t" + HTMLSVGString + "\n"))
 	}
 
 	// Append ending HTML tags
@@ -9,6 +9,7 @@ package main
 // Expects blockartlib.go to be in the ../blockartlib/ dir, relative to
 // this art-app.go file
 import (
+	"crypto/ecdsa"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -21,6 +22,7 @@ import (
 	"strings"
 
 	"./blockartlib"
+	"./utils"
 )
 
 ////// TYPES FOR THE WEBSERVER ///////
@@ -69,7 +71,7 @@ func main() {
 	fmt.Println(canvas)
 	fmt.Println(settings)
 
-	generateHTML(canvas, settings)
+	generateHTML(minerAddr, *privKey, settings)
 
 	// Close the canvas.
 	ink1, err := canvas.CloseCanvas()
@@ -198,31 +200,9 @@ func checkError(err error) {
 	}
 }
 
-// Recursively get the longest blockchain
-func getLongestBlockchain(currBlockHash string, canvas blockartlib.Canvas) []string {
-	// Add current block hash to longest chain
-	longestBlockchain := []string{}
-	longestBlockchain = append(longestBlockchain, currBlockHash)
-
-	// Iterate through children of current block if any exist,
-	// Adding the longest of them all to the longest blockchain
-	children, err := canvas.GetChildren(currBlockHash)
-	checkError(err)
-
-	longestChildBlockchain := []string{}
-	for _, child := range children {
-		childBlockchain := getLongestBlockchain(child, canvas)
-		if len(childBlockchain) > len(longestChildBlockchain) {
-			longestChildBlockchain = childBlockchain
-		}
-	}
-
-	return append(longestBlockchain, longestChildBlockchain...)
-}
-
-// Generate an HTML file, filled exclusively with
-// HTML SVG strings from the longest blockchain in canvas
-func generateHTML(canvas blockartlib.Canvas, settings blockartlib.CanvasSettings) {
+// Generate an HTML file, filled exclusively with HTML SVG strings
+// of the shapes live on the longest blockchain
+func generateHTML(minerAddr string, privKey ecdsa.PrivateKey, settings blockartlib.CanvasSettings) {
 	// Create a blank HTML file
 	HTML, err := os.Create("./art-app.html")
 	checkError(err)
@@ -240,32 +220,17 @@ func generateHTML(canvas blockartlib.Canvas, settings blockartlib.CanvasSettings
 	HTML.Write(pre)
 	HTML.Write(body)
 
-	// Get the longest blockchain
-	// Start with the genesis block and recursively add to chain
-	gHash, err := canvas.GetGenesisBlock()
-	fmt.Println("GetGenesisBlock")
+	// Get the shapes still on the canvas at the tip of the longest
+	// blockchain. Deleted shapes are left out rather than painted white.
+	fmt.Println("GetCanvasOperations")
+	ops, err := blockartlib.GetCanvasOperations(minerAddr, privKey, "")
 	checkError(err)
-	blockchain := getLongestBlockchain(gHash, canvas)
-	// svgPaths := make([]string, 0)
-	// Add the HTML SVG string of each opeartion in the blockchain
-	fmt.Println("GetShapes")
-	for _, bHash := range blockchain {
-		sHashes, err := canvas.GetShapes(bHash)
-		checkError(err)
-		for _, sHash := range sHashes {
-			HTMLSVGString, err := canvas.GetSvgString(sHash)
-			// Expect to see an InvalidShapeHashError
-			// as the first line was deleted, but art-node can
-			// never tell strictly by shapeHash
-			if err == nil {
-				fmt.Println("Writing to paths.HTML")
-				HTML.Write([]byte("\t\t" + HTMLSVGString + "\n"))
-				pathsHTML.Write([]byte(HTMLSVGString + "\n"))
-			} else {
-				fmt.Println("Error in svg string")
-				break
-			}
-		}
+
+	// Add the HTML SVG string of each live shape
+	for _, HTMLSVGString := range utils.GetLiveSVGStrings(ops) {
+		fmt.Println("Writing to paths.HTML")
+		HTML.Write([]byte("\t\t" + HTMLSVGString + "\n"))
+		pathsHTML.Write([]byte(HTMLSVGString + "\n"))
 	}
 
 	// Append ending HTML tags
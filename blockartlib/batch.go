@@ -0,0 +1,62 @@
+/*
+
+Atomic batches of shapes, built on the miner's DrawBatch and SubmitBatch
+calls. All shapes of a batch are mined into the same block, or none is.
+The returned shape hash deletes the whole batch with Canvas.DeleteShape.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../blockchain"
+	"../libminer"
+)
+
+// Adds shapes as one operation and waits for validateNum confirmations.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InsufficientInkError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - ShapeOverlapError, also if two shapes of the batch overlap
+// - OutOfBoundsError
+func AddBatch(minerAddr string, privKey ecdsa.PrivateKey, validateNum uint8,
+	shapes []blockchain.BatchShape) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer client.Close()
+
+	req := libminer.BatchRequest{Shapes: shapes, ValidateNum: validateNum}
+	var resp libminer.DrawResponse
+	if err = callMiner(client, "LibMinerInterface.DrawBatch", req, &privKey, &resp); err != nil {
+		return "", "", 0, err
+	}
+	return resp.ShapeHash, resp.BlockHash, resp.InkRemaining, nil
+}
+
+// Submits shapes as one operation and returns its shape hash right away.
+// Follow it with GetOpStatus or WaitForOps.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - OutOfBoundsError
+func SubmitBatch(minerAddr string, privKey ecdsa.PrivateKey, shapes []blockchain.BatchShape) (shapeHash string, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	req := libminer.BatchRequest{Shapes: shapes}
+	var resp libminer.SubmitResponse
+	err = callMiner(client, "LibMinerInterface.SubmitBatch", req, &privKey, &resp)
+	return resp.ShapeHash, err
+}
@@ -0,0 +1,78 @@
+/*
+
+Canvas diffs for reviewing what changed between two blocks, built on the
+miner's GetCanvasDiff call. The diff can be read as JSON (the response as is)
+or drawn as an SVG with DiffSVG.
+
+*/
+
+package blockartlib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+
+	"../blockchain"
+	"../libminer"
+	"../utils"
+)
+
+// Returns the shapes added and deleted going from fromBlockHash (empty for
+// the genesis block) to toBlockHash (empty for the tip of the longest chain).
+// The blocks may be on different forks.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func GetCanvasDiff(minerAddr string, privKey ecdsa.PrivateKey, fromBlockHash, toBlockHash string) (diff libminer.CanvasDiffResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return diff, err
+	}
+	defer client.Close()
+
+	req := libminer.CanvasDiffRequest{FromBlockHash: fromBlockHash, ToBlockHash: toBlockHash}
+	err = callMiner(client, "LibMinerInterface.GetCanvasDiff", req, &privKey, &diff)
+	return diff, err
+}
+
+// Draws diff as an SVG document: added shapes in their own colors, deleted
+// shapes as dashed red outlines, and the changed region as a dashed box.
+func DiffSVG(diff libminer.CanvasDiffResponse, canvasXMax, canvasYMax uint32) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" version=\"1.1\">\n",
+		canvasXMax, canvasYMax)
+	fmt.Fprintf(&buf, "\t<!-- diff %s..%s (ancestor %s) -->\n", diff.FromBlockHash, diff.ToBlockHash, diff.AncestorHash)
+
+	buf.WriteString("\t<g id=\"deleted\" stroke-dasharray=\"4,2\" opacity=\"0.6\">\n")
+	for _, shape := range diff.Deleted {
+		// A deleted batch is outlined member by member
+		members := []blockchain.Operation{shape.Op}
+		if shape.Op.OpType == blockchain.BATCH {
+			var err error
+			if members, err = blockchain.BatchShapes(shape.Op); err != nil {
+				continue
+			}
+		}
+		for _, op := range members {
+			op.OpType, op.Fill, op.Stroke = blockchain.ADD, "transparent", "red"
+			buf.WriteString("\t\t" + utils.GetHTMLSVGString(op) + "\n")
+		}
+	}
+	buf.WriteString("\t</g>\n")
+
+	buf.WriteString("\t<g id=\"added\">\n")
+	for _, shape := range diff.Added {
+		buf.WriteString("\t\t" + utils.GetHTMLSVGString(shape.Op) + "\n")
+	}
+	buf.WriteString("\t</g>\n")
+
+	if r := diff.Region; !r.Empty {
+		fmt.Fprintf(&buf, "\t<rect id=\"region\" x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"none\" stroke=\"blue\" stroke-dasharray=\"2,2\"/>\n",
+			r.XMin, r.YMin, r.XMax-r.XMin+1, r.YMax-r.YMin+1)
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.String()
+}
@@ -0,0 +1,45 @@
+/*
+
+Canvas time-travel for viewers, built on the miner's GetCanvasState call.
+Returns the shapes that were on the canvas at any block, so a viewer can scrub
+through the history of the longest chain (or of a fork).
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Returns the canvas as it was at blockHash.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func GetCanvasAtBlock(minerAddr string, privKey ecdsa.PrivateKey, blockHash string) (state libminer.CanvasStateResponse, err error) {
+	return getCanvasState(minerAddr, privKey, libminer.CanvasStateRequest{BlockHash: blockHash})
+}
+
+// Returns the canvas as it was at height on the longest chain, the genesis
+// block being 0. A negative height returns the current canvas.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHeightError
+func GetCanvasAtHeight(minerAddr string, privKey ecdsa.PrivateKey, height int) (state libminer.CanvasStateResponse, err error) {
+	return getCanvasState(minerAddr, privKey, libminer.CanvasStateRequest{Height: height})
+}
+
+func getCanvasState(minerAddr string, privKey ecdsa.PrivateKey, req libminer.CanvasStateRequest) (state libminer.CanvasStateResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return state, err
+	}
+	defer client.Close()
+
+	err = callMiner(client, "LibMinerInterface.GetCanvasState", req, &privKey, &state)
+	return state, err
+}
@@ -0,0 +1,82 @@
+/*
+
+Live canvas updates for art apps, built on the miner's GetCanvasEvents call.
+Replaces walking the chain with GetChildren/GetShapes from the genesis block
+every time the canvas should be refreshed.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+	"net/rpc"
+
+	"../libminer"
+)
+
+// Seconds each long-poll waits on the miner before asking again
+const EVENT_POLL_WAIT = 30
+
+// Sends every canvas event after fromBlockHash (empty for the genesis block)
+// to events until done is closed. Reconnecting later with the BlockHash of the
+// last EVENT_NEW_BLOCK received picks up where this left off.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func SubscribeCanvas(minerAddr string, privKey ecdsa.PrivateKey, fromBlockHash string,
+	events chan<- libminer.CanvasEvent, done <-chan bool) error {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		req := libminer.CanvasEventsRequest{FromBlockHash: fromBlockHash, MaxWait: EVENT_POLL_WAIT}
+		var resp libminer.CanvasEventsResponse
+		if err := callMiner(client, "LibMinerInterface.GetCanvasEvents", req, &privKey, &resp); err != nil {
+			if err == rpc.ErrShutdown {
+				return DisconnectedError(minerAddr)
+			}
+			return err
+		}
+
+		for _, event := range resp.Events {
+			select {
+			case events <- event:
+			case <-done:
+				return nil
+			}
+		}
+		fromBlockHash = resp.TipHash
+	}
+}
+
+// Returns every canvas event from the genesis block to the tip of the longest
+// chain, without waiting for new blocks. Replaying them in order rebuilds the
+// whole history of the canvas.
+//
+// Can return the following errors:
+// - DisconnectedError
+func GetCanvasHistory(minerAddr string, privKey ecdsa.PrivateKey) (events []libminer.CanvasEvent, tipHash string, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.Close()
+
+	req := libminer.CanvasEventsRequest{MaxWait: 0}
+	var resp libminer.CanvasEventsResponse
+	if err = callMiner(client, "LibMinerInterface.GetCanvasEvents", req, &privKey, &resp); err != nil {
+		return nil, "", err
+	}
+	return resp.Events, resp.TipHash, nil
+}
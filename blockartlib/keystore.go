@@ -0,0 +1,26 @@
+/*
+
+Helpers for opening a canvas with an identity from the key store
+(see ../keystore) instead of a hex private key embedded in the art app.
+
+*/
+
+package blockartlib
+
+import "../keystore"
+
+// Loads the key named keyName from the key store in keyDir and calls
+// OpenCanvas with it. keyDir may be empty to use the default directory.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - keystore.KeyNotFoundError
+// - keystore.WrongPassphraseError
+func OpenCanvasWithKeyStore(minerAddr, keyDir, keyName, passphrase string) (canvas Canvas, setting CanvasSettings, err error) {
+	privKey, err := keystore.New(keyDir).Load(keyName, passphrase)
+	if err != nil {
+		return canvas, setting, err
+	}
+
+	return OpenCanvas(minerAddr, *privKey)
+}
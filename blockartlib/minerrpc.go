@@ -0,0 +1,51 @@
+/*
+
+Shared helper for the calls added on top of the original blockartlib API.
+Signs a message the same way the miner's Verify expects: HashedMsg is the
+md5 of Msg, and (R, S) is the ECDSA signature of HashedMsg.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"net/rpc"
+
+	"../libminer"
+	"../transport"
+)
+
+// Marshals msg, signs it with privKey and calls method on the miner
+func callMiner(client *rpc.Client, method string, msg interface{}, privKey *ecdsa.PrivateKey, reply interface{}) error {
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	h := md5.New()
+	h.Write(msgBytes)
+	hashedMsg := h.Sum(nil)
+
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hashedMsg)
+	if err != nil {
+		return err
+	}
+
+	req := libminer.Request{Msg: msgBytes, HashedMsg: hashedMsg, R: *r, S: *s}
+	return client.Call(method, &req, reply)
+}
+
+// Opens a fresh connection to the miner for long running calls, so they don't
+// hold up the connection used by the Canvas. The connection is encrypted, and
+// fails unless the miner proves it owns the key pair of privKey.
+func dialMiner(minerAddr string, privKey *ecdsa.PrivateKey) (*rpc.Client, error) {
+	conn, err := transport.Dial(minerAddr, privKey, transport.ExpectKey(&privKey.PublicKey))
+	if err != nil {
+		return nil, DisconnectedError(minerAddr)
+	}
+	return rpc.NewClient(conn), nil
+}
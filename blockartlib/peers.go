@@ -0,0 +1,47 @@
+/*
+
+Peer administration for the operator of a miner, built on the miner's
+ListPeers and UnbanPeer calls. Only the holder of the miner's key can make
+these calls.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Returns the miner's peers with their misbehaviour scores and bans.
+//
+// Can return the following errors:
+// - DisconnectedError
+func ListPeers(minerAddr string, privKey ecdsa.PrivateKey) (peers []libminer.PeerInfo, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var resp libminer.PeersResponse
+	err = callMiner(client, "LibMinerInterface.ListPeers", struct{}{}, &privKey, &resp)
+	return resp.Peers, err
+}
+
+// Lifts the ban on the peer at peerAddr and restores its score. Returns
+// whether it was banned.
+//
+// Can return the following errors:
+// - DisconnectedError
+func UnbanPeer(minerAddr string, privKey ecdsa.PrivateKey, peerAddr string) (wasBanned bool, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	err = callMiner(client, "LibMinerInterface.UnbanPeer", libminer.UnbanRequest{Addr: peerAddr}, &privKey, &wasBanned)
+	return wasBanned, err
+}
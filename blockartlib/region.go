@@ -0,0 +1,53 @@
+/*
+
+Region queries on the current canvas, built on the miner's GetShapesInRegion
+and GetShapesAtPoint calls. Unlike Canvas.GetShapes these don't need a block
+hash: they answer for the tip of the longest chain, which is returned along
+with the shapes.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Returns every live shape covering at least one pixel of the rectangle
+// (xMin, yMin)-(xMax, yMax), bounds inclusive, in the order they were added.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - OutOfBoundsError, if the rectangle isn't within the canvas
+func GetShapesInRegion(minerAddr string, privKey ecdsa.PrivateKey, xMin, yMin, xMax, yMax int) (resp libminer.RegionResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return resp, err
+	}
+	defer client.Close()
+
+	req := libminer.RegionRequest{Region: libminer.Rect{XMin: xMin, YMin: yMin, XMax: xMax, YMax: yMax}}
+	err = callMiner(client, "LibMinerInterface.GetShapesInRegion", req, &privKey, &resp)
+	return resp, err
+}
+
+// Returns the live shapes covering the pixel at (x, y), topmost first. Only
+// shapes of the same owner can overlap, so all but the first (if any) belong
+// to its owner.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - OutOfBoundsError, if the pixel isn't on the canvas
+func GetShapesAtPoint(minerAddr string, privKey ecdsa.PrivateKey, x, y int) (resp libminer.RegionResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return resp, err
+	}
+	defer client.Close()
+
+	req := libminer.PointRequest{X: x, Y: y}
+	err = callMiner(client, "LibMinerInterface.GetShapesAtPoint", req, &privKey, &resp)
+	return resp, err
+}
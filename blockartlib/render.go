@@ -0,0 +1,34 @@
+/*
+
+Server-side rendering of the canvas, built on the miner's RenderPNG call.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Returns the canvas at blockHash (empty for the tip of the longest chain) as
+// a PNG. If maxSize > 0 the image is scaled down so neither side is longer.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func RenderPNG(minerAddr string, privKey ecdsa.PrivateKey, blockHash string, maxSize int) (png []byte, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	req := libminer.RenderRequest{BlockHash: blockHash, MaxSize: maxSize}
+	var resp libminer.RenderResponse
+	if err = callMiner(client, "LibMinerInterface.RenderPNG", req, &privKey, &resp); err != nil {
+		return nil, err
+	}
+	return resp.PNG, nil
+}
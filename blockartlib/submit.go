@@ -0,0 +1,112 @@
+/*
+
+Asynchronous submission of operations, built on the miner's SubmitShape,
+SubmitDelete and GetOpStatus calls. Unlike Canvas.AddShape these return as
+soon as the miner has the operation, so many can be in flight at once.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+	"net/rpc"
+	"time"
+
+	"../blockchain"
+	"../libminer"
+)
+
+// How often WaitForOps asks the miner for the state of the operations
+const OP_POLL_INTERVAL = 1 * time.Second
+
+// Submits every op (ADDs only) over one connection and returns their shape
+// hashes, in the same order. Stops at the first error.
+//
+// Can return the following errors:
+// - DisconnectedError
+func SubmitShapes(minerAddr string, privKey ecdsa.PrivateKey, ops []blockchain.Operation) (shapeHashes []string, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	for _, op := range ops {
+		req := libminer.DrawRequest{SVGString: op.SVGString, Fill: op.Fill, Stroke: op.Stroke}
+		var resp libminer.SubmitResponse
+		if err = callMiner(client, "LibMinerInterface.SubmitShape", req, &privKey, &resp); err != nil {
+			return shapeHashes, err
+		}
+		shapeHashes = append(shapeHashes, resp.ShapeHash)
+	}
+	return shapeHashes, nil
+}
+
+// Returns the state of an operation submitted through this miner.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeHashError
+func GetOpStatus(minerAddr string, privKey ecdsa.PrivateKey, shapeHash string) (status libminer.OpStatusResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return status, err
+	}
+	defer client.Close()
+
+	err = callMiner(client, "LibMinerInterface.GetOpStatus", libminer.OpStatusRequest{ShapeHash: shapeHash}, &privKey, &status)
+	return status, err
+}
+
+// Waits until every op in shapeHashes has validateNum confirmations or was
+// rejected, or timeout runs out. Returns the last known status of each.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeHashError
+// - OperationTimeoutError with the first op that isn't done
+func WaitForOps(minerAddr string, privKey ecdsa.PrivateKey, shapeHashes []string,
+	validateNum uint8, timeout time.Duration) (statuses []libminer.OpStatusResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(timeout)
+	statuses = make([]libminer.OpStatusResponse, len(shapeHashes))
+	done := make([]bool, len(shapeHashes))
+
+	for {
+		pending := ""
+		for i, shapeHash := range shapeHashes {
+			if done[i] {
+				continue
+			}
+
+			req := libminer.OpStatusRequest{ShapeHash: shapeHash}
+			if err = callMiner(client, "LibMinerInterface.GetOpStatus", req, &privKey, &statuses[i]); err != nil {
+				if err == rpc.ErrShutdown {
+					return statuses, DisconnectedError(minerAddr)
+				}
+				return statuses, err
+			}
+
+			status := statuses[i]
+			done[i] = status.State == libminer.OP_REJECTED ||
+				(status.State == libminer.OP_INCLUDED && status.Confirmations >= int(validateNum))
+			if !done[i] && pending == "" {
+				pending = shapeHash
+			}
+		}
+
+		if pending == "" {
+			return statuses, nil
+		}
+		if time.Now().After(deadline) {
+			return statuses, libminer.OperationTimeoutError(pending)
+		}
+		time.Sleep(OP_POLL_INTERVAL)
+	}
+}
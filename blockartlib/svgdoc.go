@@ -0,0 +1,50 @@
+/*
+
+SVG output of the canvas that leaves deleted shapes out, instead of painting
+them over in white like the strings from GetSvgString do.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../blockchain"
+	"../libminer"
+	"../utils"
+)
+
+// Returns the ops of the shapes on the canvas at blockHash (empty for the tip
+// of the longest chain), in block order. Pass them to utils.GetSVGDocument or
+// utils.GetLiveSVGStrings.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func GetCanvasOperations(minerAddr string, privKey ecdsa.PrivateKey, blockHash string) (ops []blockchain.OperationInfo, err error) {
+	state, err := getCanvasState(minerAddr, privKey, libminer.CanvasStateRequest{BlockHash: blockHash, Height: -1})
+	if err != nil {
+		return nil, err
+	}
+
+	ops = make([]blockchain.OperationInfo, len(state.Shapes))
+	for i, shape := range state.Shapes {
+		ops[i] = blockchain.OperationInfo{OpSig: shape.ShapeHash, PubKey: shape.Owner, Op: shape.Op}
+	}
+	return ops, nil
+}
+
+// Returns the canvas at blockHash (empty for the tip of the longest chain) as
+// a standalone SVG document.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func GetCanvasSVG(minerAddr string, privKey ecdsa.PrivateKey, blockHash string, settings CanvasSettings) (svg string, err error) {
+	ops, err := GetCanvasOperations(minerAddr, privKey, blockHash)
+	if err != nil {
+		return "", err
+	}
+	return utils.GetSVGDocument(ops, settings.CanvasXMax, settings.CanvasYMax), nil
+}
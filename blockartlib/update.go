@@ -0,0 +1,73 @@
+/*
+
+Moving and restyling shapes, built on the miner's Update and SubmitUpdate
+calls. The new shape replaces the old one in a single operation, so no other
+application can draw into the freed space in between, and only the
+difference in ink is charged or refunded.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Replaces the shape shapeHash, which must be owned by privKey, with a new
+// shape and waits for validateNum confirmations. The new shape may overlap
+// the one it replaces. Returns the hash of the new shape; the old hash can no
+// longer be deleted or updated.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InsufficientInkError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - ShapeOverlapError
+// - OutOfBoundsError
+// - ShapeOwnerError
+func UpdateShape(minerAddr string, privKey ecdsa.PrivateKey, validateNum uint8, shapeHash string,
+	shapeSvgString string, fill string, stroke string) (newShapeHash string, blockHash string, inkRemaining uint32, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer client.Close()
+
+	req := libminer.UpdateRequest{
+		ShapeHash:   shapeHash,
+		SVGString:   shapeSvgString,
+		Fill:        fill,
+		Stroke:      stroke,
+		ValidateNum: validateNum}
+	var resp libminer.DrawResponse
+	if err = callMiner(client, "LibMinerInterface.Update", req, &privKey, &resp); err != nil {
+		return "", "", 0, err
+	}
+	return resp.ShapeHash, resp.BlockHash, resp.InkRemaining, nil
+}
+
+// Submits the replacement of shapeHash and returns the new shape hash right
+// away. Follow it with GetOpStatus or WaitForOps.
+//
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - OutOfBoundsError
+// - ShapeOwnerError
+func SubmitUpdate(minerAddr string, privKey ecdsa.PrivateKey, shapeHash string,
+	shapeSvgString string, fill string, stroke string) (newShapeHash string, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	req := libminer.UpdateRequest{ShapeHash: shapeHash, SVGString: shapeSvgString, Fill: fill, Stroke: stroke}
+	var resp libminer.SubmitResponse
+	err = callMiner(client, "LibMinerInterface.SubmitUpdate", req, &privKey, &resp)
+	return resp.ShapeHash, err
+}
@@ -0,0 +1,35 @@
+/*
+
+Dry run of Canvas.AddShape, built on the miner's ValidateShape call. Tells
+an art app up front whether a shape would be accepted, what it costs and
+which shapes are in its way, instead of after minutes of waiting in AddShape.
+
+*/
+
+package blockartlib
+
+import (
+	"crypto/ecdsa"
+
+	"../libminer"
+)
+
+// Checks a shape against the current canvas without submitting it. result.Error
+// is empty if AddShape would accept the shape right now, and otherwise holds
+// the error AddShape would fail with. The shape may still be rejected later if
+// the canvas changes before it is mined.
+//
+// Can return the following errors:
+// - DisconnectedError
+func ValidateShape(minerAddr string, privKey ecdsa.PrivateKey, shapeSvgString string,
+	fill string, stroke string) (result libminer.ValidateShapeResponse, err error) {
+	client, err := dialMiner(minerAddr, &privKey)
+	if err != nil {
+		return result, err
+	}
+	defer client.Close()
+
+	req := libminer.ValidateShapeRequest{SVGString: shapeSvgString, Fill: fill, Stroke: stroke}
+	err = callMiner(client, "LibMinerInterface.ValidateShape", req, &privKey, &result)
+	return result, err
+}
@@ -0,0 +1,99 @@
+/*
+
+BATCH operations: several shapes that are validated, mined and deleted as one
+operation. Either every shape makes it onto the canvas or none does.
+
+The shapes are stored JSON encoded in Operation.SVGString, behind
+BATCH_PREFIX, so the Operation layout (and its signature) stays the same. Only
+an op whose OpType is BATCH is a batch. A DELETE of a batch copies that string
+like any other DELETE, and miners check the copy matches the deleted op, so
+DeletedOp can tell what a DELETE removes. Its ink refund is computed from the
+deleted op in the chain, never from the copy.
+
+*/
+
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// OpType of a batch, following ADD and DELETE
+	BATCH = DELETE + 1
+
+	// Marks the SVGString of a batch (or of the DELETE of one)
+	BATCH_PREFIX = "batch:"
+
+	// Most shapes a batch can hold
+	MAX_BATCH_SHAPES = 32
+)
+
+// One shape of a batch
+type BatchShape struct {
+	SVGString string
+	Fill      string
+	Stroke    string
+}
+
+// Builds the BATCH operation drawing shapes
+func NewBatchOperation(shapes []BatchShape, opNum uint64) (Operation, error) {
+	if len(shapes) == 0 || len(shapes) > MAX_BATCH_SHAPES {
+		return Operation{}, fmt.Errorf("batch must hold 1 to %d shapes, got %d", MAX_BATCH_SHAPES, len(shapes))
+	}
+
+	encoded, err := json.Marshal(shapes)
+	if err != nil {
+		return Operation{}, err
+	}
+
+	return Operation{
+		OpType:    BATCH,
+		SVGString: BATCH_PREFIX + string(encoded),
+		OpNum:     opNum}, nil
+}
+
+// Returns the shapes of a BATCH operation as ADD operations
+func BatchShapes(op Operation) ([]Operation, error) {
+	if op.OpType != BATCH {
+		return nil, fmt.Errorf("op type %d is not a batch", op.OpType)
+	}
+	if !strings.HasPrefix(op.SVGString, BATCH_PREFIX) {
+		return nil, fmt.Errorf("batch without %q prefix", BATCH_PREFIX)
+	}
+
+	var shapes []BatchShape
+	if err := json.Unmarshal([]byte(op.SVGString[len(BATCH_PREFIX):]), &shapes); err != nil {
+		return nil, fmt.Errorf("batch shapes: %s", err)
+	}
+	if len(shapes) == 0 || len(shapes) > MAX_BATCH_SHAPES {
+		return nil, fmt.Errorf("batch must hold 1 to %d shapes, got %d", MAX_BATCH_SHAPES, len(shapes))
+	}
+
+	ops := make([]Operation, len(shapes))
+	for i, shape := range shapes {
+		ops[i] = Operation{
+			OpType:    ADD,
+			SVGString: shape.SVGString,
+			Fill:      shape.Fill,
+			Stroke:    shape.Stroke,
+			OpNum:     op.OpNum}
+	}
+	return ops, nil
+}
+
+// Returns the op a DELETE removes, rebuilt from the copy the DELETE carries:
+// a BATCH if it deleted a batch, otherwise an ADD. For display only; the
+// miners look the deleted op up by the DELETE's AddSig.
+func DeletedOp(op Operation) Operation {
+	deleted := op
+	deleted.OpType = ADD
+	// A shape's own SVGString is a path or circle, so only a batch has the
+	// prefix
+	if strings.HasPrefix(op.SVGString, BATCH_PREFIX) {
+		deleted.OpType = BATCH
+	}
+	return deleted
+}
@@ -0,0 +1,28 @@
+/*
+
+UPDATE operations: replace one of the owner's shapes with a new one in a
+single step, so nobody else can draw into the space in between.
+
+An UPDATE is shaped like an ADD of the new shape (Operation holds the new
+geometry and colors) that also names the replaced shape in AddSig, like a
+DELETE does. Its OpSig is the hash of the new shape. The owner pays the
+difference in ink between the new and the replaced shape.
+
+*/
+
+package blockchain
+
+// OpType of an update, following BATCH
+const UPDATE = BATCH + 1
+
+// Returns the operation in chain whose OpSig is opSig
+func FindOperation(chain []Block, opSig string) (OperationInfo, bool) {
+	for _, block := range chain {
+		for _, opInfo := range block.OpHistory {
+			if opInfo.OpSig == opSig {
+				return opInfo, true
+			}
+		}
+	}
+	return OperationInfo{}, false
+}
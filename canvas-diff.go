@@ -0,0 +1,101 @@
+/*
+
+Prints what changed on the canvas between two blocks.
+
+Usage:
+go run canvas-diff.go -key name [-svg] [-o file] <from block hash> [to block hash]
+  -key string
+    	Name of the key to use from the key store (required)
+  -keydir string
+    	Key store directory (default "./keys")
+  -svg
+    	Print the diff as an SVG document instead of JSON
+  -o string
+    	Output file (default: stdout)
+
+An empty from hash ("") starts at the genesis block. Leaving out the to hash
+compares against the tip of the longest chain.
+
+Reads the miner address from ./ip-ports.txt. The private key is loaded from
+the key store (see misc/keytool.go), with the passphrase taken from
+$INKCOIN_PASSPHRASE or stdin.
+*/
+
+package main
+
+import (
+	"./blockartlib"
+	"./keystore"
+)
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+	asSVG := flag.Bool("svg", false, "Print the diff as an SVG document instead of JSON")
+	out := flag.String("o", "", "Output file (default: stdout)")
+	keyName := flag.String("key", "", "Name of the key to use from the key store (required)")
+	keyDir := flag.String("keydir", keystore.DEFAULT_DIR, "Key store directory")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 || *keyName == "" {
+		fmt.Println("Usage: go run canvas-diff.go -key name [-svg] [-o file] <from block hash> [to block hash]")
+		return
+	}
+	toHash := ""
+	if len(args) == 2 {
+		toHash = args[1]
+	}
+
+	// Read file content and cast to string
+	ipPortBytes, err := ioutil.ReadFile("./ip-ports.txt")
+	checkError(err)
+	ipPortString := string(ipPortBytes[:])
+
+	// Parse ip-port from content string
+	minerAddr := strings.Split(ipPortString, "\n")[0]
+
+	passphrase, err := keystore.ReadPassphrase()
+	checkError(err)
+	privKey, err := keystore.New(*keyDir).Load(*keyName, passphrase)
+	checkError(err)
+
+	diff, err := blockartlib.GetCanvasDiff(minerAddr, *privKey, args[0], toHash)
+	checkError(err)
+
+	var output []byte
+	if *asSVG {
+		// The canvas size is only known after opening a canvas
+		canvas, settings, err := blockartlib.OpenCanvas(minerAddr, *privKey)
+		checkError(err)
+		canvas.CloseCanvas()
+
+		output = []byte(blockartlib.DiffSVG(diff, settings.CanvasXMax, settings.CanvasYMax))
+	} else {
+		output, err = json.MarshalIndent(diff, "", "  ")
+		checkError(err)
+		output = append(output, '\n')
+	}
+
+	if *out == "" {
+		os.Stdout.Write(output)
+	} else {
+		err = ioutil.WriteFile(*out, output, 0644)
+		checkError(err)
+	}
+}
+
+// If error is non-nil, print it out and exit.
+func checkError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error ", err.Error())
+		os.Exit(1)
+	}
+}
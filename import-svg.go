@@ -0,0 +1,154 @@
+/*
+
+Imports an SVG file made in a regular editor and draws it as a batch.
+
+Usage:
+go run import-svg.go -key name [-n validateNum] [-timeout sec] [-dry-run] <file.svg>
+  -key string
+    	Name of the key to use from the key store (required)
+  -keydir string
+    	Key store directory (default "./keys")
+  -n int
+    	Confirmations to wait for (default 2)
+  -timeout int
+    	Seconds to wait for the whole batch (default 600)
+  -dry-run
+    	Only convert and pre-check, don't submit
+
+Every element is converted into operations and checked against the current
+canvas (bounds, overlaps with other art nodes, total ink) before anything is
+submitted. Elements that can't be converted are listed and left out.
+
+The operations are submitted as BATCH operations of up to 32 shapes, each of
+which is drawn completely or not at all. Shapes of the document that overlap
+each other go into different batches, since miners reject a batch whose
+shapes overlap.
+
+Reads the miner address from ./ip-ports.txt. The private key is loaded from
+the key store (see misc/keytool.go), with the passphrase taken from
+$INKCOIN_PASSPHRASE or stdin.
+*/
+
+package main
+
+import (
+	"./blockartlib"
+	"./blockchain"
+	"./keystore"
+	"./libminer"
+	"./svgimport"
+	"./utils"
+)
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	validateNum := flag.Int("n", 2, "Confirmations to wait for")
+	timeout := flag.Int("timeout", 600, "Seconds to wait for the whole batch")
+	dryRun := flag.Bool("dry-run", false, "Only convert and pre-check, don't submit")
+	keyName := flag.String("key", "", "Name of the key to use from the key store (required)")
+	keyDir := flag.String("keydir", keystore.DEFAULT_DIR, "Key store directory")
+	flag.Parse()
+
+	if flag.NArg() != 1 || *keyName == "" {
+		fmt.Println("Usage: go run import-svg.go -key name [-n validateNum] [-timeout sec] [-dry-run] <file.svg>")
+		return
+	}
+
+	// Read file content and cast to string
+	ipPortBytes, err := ioutil.ReadFile("./ip-ports.txt")
+	checkError(err)
+	ipPortString := string(ipPortBytes[:])
+
+	// Parse ip-port from content string
+	minerAddr := strings.Split(ipPortString, "\n")[0]
+
+	passphrase, err := keystore.ReadPassphrase()
+	checkError(err)
+	privKey, err := keystore.New(*keyDir).Load(*keyName, passphrase)
+	checkError(err)
+
+	// Convert the document
+	file, err := os.Open(flag.Arg(0))
+	checkError(err)
+	shapes, skipped, err := svgimport.Parse(file)
+	file.Close()
+	checkError(err)
+
+	for _, skipErr := range skipped {
+		fmt.Println("Skipped", skipErr)
+	}
+	fmt.Printf("Converted %d operations\n", len(shapes))
+	if len(shapes) == 0 {
+		return
+	}
+
+	// Pre-check against the current canvas
+	canvas, settings, err := blockartlib.OpenCanvas(minerAddr, *privKey)
+	checkError(err)
+	ink, err := canvas.GetInk()
+	checkError(err)
+	canvas.CloseCanvas()
+
+	state, err := blockartlib.GetCanvasAtHeight(minerAddr, *privKey, -1)
+	checkError(err)
+
+	pubKey := utils.GetPublicKeyString(privKey.PublicKey)
+	cost, err := svgimport.PreCheck(shapes, state.Shapes, pubKey, ink,
+		svgimport.CanvasSettings{CanvasXMax: settings.CanvasXMax, CanvasYMax: settings.CanvasYMax})
+	checkError(err)
+	fmt.Printf("Pre-check passed: %d ink needed, %d available\n", cost, ink)
+
+	if *dryRun {
+		for _, shape := range shapes {
+			fmt.Printf("%s\t%s\n", shape.Source, shape.Op.SVGString)
+		}
+		return
+	}
+
+	// Submit the shapes as atomic batches, then wait for all of them.
+	// Miners reject a batch whose shapes overlap each other, so overlapping
+	// shapes are split into separate batches.
+	batches, err := svgimport.SplitBatches(shapes, blockchain.MAX_BATCH_SHAPES,
+		svgimport.CanvasSettings{CanvasXMax: settings.CanvasXMax, CanvasYMax: settings.CanvasYMax})
+	checkError(err)
+
+	shapeHashes := make([]string, 0, len(batches))
+	for _, batch := range batches {
+		batchShapes := make([]blockchain.BatchShape, len(batch))
+		for i, shape := range batch {
+			batchShapes[i] = blockchain.BatchShape{SVGString: shape.Op.SVGString, Fill: shape.Op.Fill, Stroke: shape.Op.Stroke}
+		}
+
+		shapeHash, err := blockartlib.SubmitBatch(minerAddr, *privKey, batchShapes)
+		checkError(err)
+		shapeHashes = append(shapeHashes, shapeHash)
+	}
+	fmt.Printf("Submitted %d batches, waiting for %d confirmations\n", len(shapeHashes), *validateNum)
+
+	statuses, err := blockartlib.WaitForOps(minerAddr, *privKey, shapeHashes,
+		uint8(*validateNum), time.Duration(*timeout)*time.Second)
+	for i, status := range statuses {
+		batch := batches[i]
+		fmt.Printf("%s .. %s\t%s\t%s %s\n", batch[0].Source, batch[len(batch)-1].Source, shapeHashes[i], status.State, status.Reason)
+		if status.State == libminer.OP_REJECTED && err == nil {
+			err = fmt.Errorf("batch %d was rejected", i)
+		}
+	}
+	checkError(err)
+}
+
+// If error is non-nil, print it out and exit.
+func checkError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error ", err.Error())
+		os.Exit(1)
+	}
+}
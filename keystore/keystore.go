@@ -0,0 +1,394 @@
+/*
+
+This package stores the ECDSA identities used by miners and art apps on disk,
+encrypted with a passphrase, so keys no longer have to be passed around as raw
+hex on the command line or left in key-pairs.txt.
+
+Each identity is a single JSON file named <name>.json inside the key store
+directory. The private key is marshalled with x509.MarshalECPrivateKey, wrapped
+in a PEM block and sealed with AES-256-GCM using a key derived from the
+passphrase (PBKDF2-HMAC-SHA256). The public key is kept in the clear as the
+same hex string used everywhere else (utils.GetPublicKeyString), so keys can
+be listed without a passphrase.
+
+Public functions:
+
+	New(dir string) -> *KeyStore
+
+	ParseHexKey(privKey string) -> *ecdsa.PrivateKey, error
+
+	ParsePEMKey(data []byte) -> *ecdsa.PrivateKey, error
+
+	PublicKeyString(pubKey ecdsa.PublicKey) -> string
+
+	ReadPassphrase() -> string, error
+
+Public types and methods:
+
+	KeyStore
+	  Generate(name, passphrase string) -> *ecdsa.PrivateKey, error
+	  Import(name, passphrase string, privKey *ecdsa.PrivateKey) -> error
+	  Load(name, passphrase string) -> *ecdsa.PrivateKey, error
+	  Export(name, passphrase string) -> []byte, error
+	  List() -> []KeyInfo, error
+
+*/
+
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Directory used when no key store directory is given
+	DEFAULT_DIR = "./keys"
+	// Number of PBKDF2 rounds used to derive the file encryption key
+	KDF_ITERATIONS = 100000
+	// Version of the key file format written by this package
+	FILE_VERSION = 1
+
+	pemType   = "EC PRIVATE KEY"
+	saltLen   = 16
+	aesKeyLen = 32
+)
+
+// Names are used as file names, so keep them to something boring
+var validName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+/*******************
+* TYPE_DEFINITIONS *
+*******************/
+
+// Errors that the key store could return.
+type KeyNotFoundError string
+
+func (e KeyNotFoundError) Error() string {
+	return fmt.Sprintf("Keystore: no key named [%s]", string(e))
+}
+
+type KeyExistsError string
+
+func (e KeyExistsError) Error() string {
+	return fmt.Sprintf("Keystore: key already exists [%s]", string(e))
+}
+
+type InvalidKeyNameError string
+
+func (e InvalidKeyNameError) Error() string {
+	return fmt.Sprintf("Keystore: invalid key name [%s]", string(e))
+}
+
+type WrongPassphraseError string
+
+func (e WrongPassphraseError) Error() string {
+	return fmt.Sprintf("Keystore: wrong passphrase for key [%s]", string(e))
+}
+
+// A directory of encrypted key files
+type KeyStore struct {
+	Dir string
+}
+
+// Public information about a stored key. Does not require the passphrase.
+type KeyInfo struct {
+	Name      string
+	PublicKey string
+	Created   time.Time
+}
+
+// On-disk representation of a single key
+type keyFile struct {
+	Version    int       `json:"version"`
+	Name       string    `json:"name"`
+	PublicKey  string    `json:"public-key"`
+	Created    time.Time `json:"created"`
+	Salt       string    `json:"salt"`
+	Iterations int       `json:"iterations"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+}
+
+/***********************
+* FUNCTION_DEFINITIONS *
+***********************/
+
+// Returns a key store rooted at dir. Uses DEFAULT_DIR if dir is empty.
+func New(dir string) *KeyStore {
+	if dir == "" {
+		dir = DEFAULT_DIR
+	}
+	return &KeyStore{dir}
+}
+
+// Generates a new P384 key pair, the curve used across the network, and
+// stores it under name.
+func (ks *KeyStore) Generate(name, passphrase string) (*ecdsa.PrivateKey, error) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ks.Import(name, passphrase, privKey); err != nil {
+		return nil, err
+	}
+	return privKey, nil
+}
+
+// Encrypts privKey with passphrase and stores it under name.
+// Possible Errors:
+// - InvalidKeyNameError
+// - KeyExistsError
+func (ks *KeyStore) Import(name, passphrase string, privKey *ecdsa.PrivateKey) error {
+	if !validName.MatchString(name) {
+		return InvalidKeyNameError(name)
+	}
+
+	path := ks.path(name)
+	if _, err := os.Stat(path); err == nil {
+		return KeyExistsError(name)
+	}
+
+	der, err := x509.MarshalECPrivateKey(privKey)
+	if err != nil {
+		return err
+	}
+	plaintext := pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})
+
+	salt := make([]byte, saltLen)
+	if _, err = rand.Read(salt); err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(passphrase, salt, KDF_ITERATIONS)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+
+	kf := keyFile{
+		Version:    FILE_VERSION,
+		Name:       name,
+		PublicKey:  PublicKeyString(privKey.PublicKey),
+		Created:    time.Now().UTC(),
+		Salt:       hex.EncodeToString(salt),
+		Iterations: KDF_ITERATIONS,
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(gcm.Seal(nil, nonce, plaintext, []byte(name)))}
+
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(ks.Dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// Decrypts and returns the key stored under name.
+// Possible Errors:
+// - InvalidKeyNameError
+// - KeyNotFoundError
+// - WrongPassphraseError
+func (ks *KeyStore) Load(name, passphrase string) (*ecdsa.PrivateKey, error) {
+	kf, err := ks.read(name)
+	if err != nil {
+		return nil, err
+	}
+
+	salt, err := hex.DecodeString(kf.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(kf.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := hex.DecodeString(kf.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(passphrase, salt, kf.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(kf.Name))
+	if err != nil {
+		return nil, WrongPassphraseError(name)
+	}
+
+	privKey, err := ParsePEMKey(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	// Guard against a file whose clear-text public key was edited
+	if PublicKeyString(privKey.PublicKey) != kf.PublicKey {
+		return nil, fmt.Errorf("Keystore: public key mismatch for key [%s]", name)
+	}
+	return privKey, nil
+}
+
+// Returns the unencrypted PEM encoding of the key stored under name, for use
+// with other tools. Handle the output with care.
+func (ks *KeyStore) Export(name, passphrase string) ([]byte, error) {
+	privKey, err := ks.Load(name, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der}), nil
+}
+
+// Lists all keys in the store, sorted by name
+func (ks *KeyStore) List() ([]KeyInfo, error) {
+	infos := make([]KeyInfo, 0)
+
+	files, err := ioutil.ReadDir(ks.Dir)
+	if os.IsNotExist(err) {
+		return infos, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+
+		kf, err := ks.read(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			fmt.Println("Keystore: skipping unreadable key file", f.Name(), ":", err)
+			continue
+		}
+		infos = append(infos, KeyInfo{kf.Name, kf.PublicKey, kf.Created})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Parses a hex encoded x509 EC private key, the format of the first line of
+// the old key-pairs.txt files
+func ParseHexKey(privKey string) (*ecdsa.PrivateKey, error) {
+	der, err := hex.DecodeString(strings.TrimSpace(privKey))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+// Parses a PEM encoded x509 EC private key
+func ParsePEMKey(data []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemType {
+		return nil, fmt.Errorf("Keystore: no %s PEM block found", pemType)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+// Same encoding as utils.GetPublicKeyString. Duplicated here so the key store
+// does not have to pull in the blockchain packages.
+func PublicKeyString(pubKey ecdsa.PublicKey) string {
+	publicKeyBytes, _ := x509.MarshalPKIXPublicKey(&pubKey)
+	return hex.EncodeToString(publicKeyBytes)
+}
+
+/**********
+* HELPERS *
+**********/
+
+func (ks *KeyStore) path(name string) string {
+	return filepath.Join(ks.Dir, name+".json")
+}
+
+func (ks *KeyStore) read(name string) (kf keyFile, err error) {
+	if !validName.MatchString(name) {
+		return kf, InvalidKeyNameError(name)
+	}
+
+	data, err := ioutil.ReadFile(ks.path(name))
+	if os.IsNotExist(err) {
+		return kf, KeyNotFoundError(name)
+	} else if err != nil {
+		return kf, err
+	}
+
+	err = json.Unmarshal(data, &kf)
+	return kf, err
+}
+
+// Derives an AES-256-GCM cipher from the passphrase
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2([]byte(passphrase), salt, iterations, aesKeyLen))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PBKDF2 with HMAC-SHA256 as described in RFC 8018, section 5.2
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		// U_1 = PRF(password, salt || INT(block))
+		prf.Reset()
+		prf.Write(salt)
+		buf[0] = byte(block >> 24)
+		buf[1] = byte(block >> 16)
+		buf[2] = byte(block >> 8)
+		buf[3] = byte(block)
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		// U_n = PRF(password, U_(n-1)), T = U_1 ^ U_2 ^ ... ^ U_c
+		for n := 2; n <= iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
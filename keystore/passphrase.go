@@ -0,0 +1,61 @@
+/*
+
+Reading the key store passphrase for command line tools, so they don't each
+need their own copy.
+
+*/
+
+package keystore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Environment variable holding the key store passphrase
+const PASSPHRASE_ENV = "INKCOIN_PASSPHRASE"
+
+// Returns the passphrase in PASSPHRASE_ENV if it is set, otherwise reads one
+// line from stdin. When stdin is a terminal the prompt goes to stderr and
+// echo is turned off with stty while the passphrase is typed.
+func ReadPassphrase() (string, error) {
+	if passphrase := os.Getenv(PASSPHRASE_ENV); passphrase != "" {
+		return passphrase, nil
+	}
+
+	if isTerminal(os.Stdin) {
+		if err := setEcho(false); err != nil {
+			return "", err
+		}
+		defer func() {
+			setEcho(true)
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	fmt.Fprint(os.Stderr, "Passphrase: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Turns the terminal on stdin's echo on or off
+func setEcho(on bool) error {
+	arg := "-echo"
+	if on {
+		arg = "echo"
+	}
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
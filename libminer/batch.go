@@ -0,0 +1,17 @@
+/*
+
+Request type for LibMinerInterface.DrawBatch and SubmitBatch. DrawBatch
+replies with a DrawResponse and SubmitBatch with a SubmitResponse, same as
+their single shape counterparts.
+
+*/
+
+package libminer
+
+import "../blockchain"
+
+type BatchRequest struct {
+	// At most blockchain.MAX_BATCH_SHAPES shapes, drawn all or none
+	Shapes      []blockchain.BatchShape
+	ValidateNum uint8
+}
@@ -0,0 +1,39 @@
+/*
+
+Request and response types for LibMinerInterface.GetCanvasDiff.
+
+*/
+
+package libminer
+
+type CanvasDiffRequest struct {
+	// Empty means the genesis block
+	FromBlockHash string
+	// Empty means the tip of the longest chain
+	ToBlockHash string
+}
+
+// Bounding box of the pixels that differ between the two canvases
+type DiffRegion struct {
+	// True if no pixel changed, in which case the bounds are meaningless
+	Empty bool
+	XMin  int
+	YMin  int
+	XMax  int
+	YMax  int
+	// Number of pixels covered by an added or deleted shape
+	Pixels int
+}
+
+type CanvasDiffResponse struct {
+	FromBlockHash string
+	ToBlockHash   string
+	// Last block both chains share. Equal to FromBlockHash when ToBlockHash
+	// descends from it.
+	AncestorHash string
+	// Shapes live at ToBlockHash but not at FromBlockHash
+	Added []CanvasShape
+	// Shapes live at FromBlockHash but not at ToBlockHash
+	Deleted []CanvasShape
+	Region  DiffRegion
+}
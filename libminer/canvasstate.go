@@ -0,0 +1,51 @@
+/*
+
+Request and response types for LibMinerInterface.GetCanvasState, which
+reconstructs the canvas as it was at any block.
+
+*/
+
+package libminer
+
+import (
+	"fmt"
+
+	"../blockchain"
+)
+
+type CanvasStateRequest struct {
+	// Block to reconstruct the canvas at. Takes precedence over Height.
+	BlockHash string
+	// Height on the longest chain, the genesis block being 0. Only used when
+	// BlockHash is empty; a negative height means the tip.
+	Height int
+}
+
+// A shape that is on the canvas at the requested block
+type CanvasShape struct {
+	ShapeHash string
+	// Public key of the art node that added it
+	Owner string
+	// Ink the owner was charged for it. For an UPDATE that is the cost of the
+	// new shape less the refund of the one it replaced, so it is negative if
+	// the new shape is cheaper.
+	InkCost int
+	// Block the ADD was included in, and that block's height
+	BlockHash   string
+	BlockHeight int
+	Op          blockchain.Operation
+}
+
+type CanvasStateResponse struct {
+	BlockHash string
+	Height    int
+	// Live shapes (ADDs not undone by a DELETE), in the order they were added
+	Shapes []CanvasShape
+}
+
+// Contains the requested height, which is past the tip of the longest chain
+type InvalidBlockHeightError int
+
+func (e InvalidBlockHeightError) Error() string {
+	return fmt.Sprintf("BlockArt: No block at height [%d]", int(e))
+}
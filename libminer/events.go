@@ -0,0 +1,70 @@
+/*
+
+Request and response types for the canvas event subscription
+(LibMinerInterface.GetCanvasEvents).
+
+*/
+
+package libminer
+
+import "../blockchain"
+
+type CanvasEventType int
+
+const (
+	// A block was appended to the longest chain
+	EVENT_NEW_BLOCK CanvasEventType = iota
+	// A shape became visible. Also sent when a reorg undoes a DELETE.
+	EVENT_SHAPE_ADDED
+	// A shape was removed. Also sent when a reorg undoes an ADD.
+	EVENT_SHAPE_DELETED
+	// The longest chain switched to a fork. BlockHash is the common ancestor;
+	// the events that follow undo the orphaned blocks, newest first, and then
+	// replay the new chain.
+	EVENT_REORG
+)
+
+func (t CanvasEventType) String() string {
+	switch t {
+	case EVENT_NEW_BLOCK:
+		return "new-block"
+	case EVENT_SHAPE_ADDED:
+		return "shape-added"
+	case EVENT_SHAPE_DELETED:
+		return "shape-deleted"
+	case EVENT_REORG:
+		return "reorg"
+	default:
+		return "unknown"
+	}
+}
+
+type CanvasEvent struct {
+	Type CanvasEventType
+	// Block the event belongs to
+	BlockHash string
+	// Height of BlockHash, the genesis block is 0
+	BlockIndex int
+	// Hash of the shape (the OpSig of its ADD) for shape events
+	ShapeHash string
+	// Public key of the shape owner, or of the miner for EVENT_NEW_BLOCK
+	PubKey string
+	// Geometry and colors of the shape for shape events. OpType is ADD (BATCH
+	// for a batch) for EVENT_SHAPE_ADDED and DELETE for EVENT_SHAPE_DELETED,
+	// so it can be passed straight to utils.GetHTMLSVGString.
+	Op blockchain.Operation
+}
+
+type CanvasEventsRequest struct {
+	// Last block the caller has seen. Empty means the genesis block.
+	FromBlockHash string
+	// Seconds to wait for a new block when there is nothing to report yet
+	MaxWait uint32
+}
+
+type CanvasEventsResponse struct {
+	Events []CanvasEvent
+	// Tip of the longest chain the events lead up to. Pass it as
+	// FromBlockHash in the next call.
+	TipHash string
+}
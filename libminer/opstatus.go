@@ -0,0 +1,69 @@
+/*
+
+Request and response types for the asynchronous operation API
+(LibMinerInterface.SubmitShape, SubmitDelete and GetOpStatus).
+
+*/
+
+package libminer
+
+import "fmt"
+
+// State of a submitted operation as seen by the miner
+type OpState int
+
+const (
+	// Submitted but not on the longest chain yet
+	OP_PENDING OpState = iota
+	// On the longest chain, see OpStatusResponse.Confirmations
+	OP_INCLUDED
+	// Can never be included, see OpStatusResponse.Reason
+	OP_REJECTED
+	// Was on the longest chain but a fork replaced that block. The miner
+	// resubmits it, so it may still become OP_INCLUDED again.
+	OP_REORGED
+)
+
+func (s OpState) String() string {
+	switch s {
+	case OP_PENDING:
+		return "pending"
+	case OP_INCLUDED:
+		return "included"
+	case OP_REJECTED:
+		return "rejected"
+	case OP_REORGED:
+		return "reorged"
+	default:
+		return "unknown"
+	}
+}
+
+// Returned by SubmitShape and SubmitDelete. ShapeHash identifies the
+// submitted operation in GetOpStatus.
+type SubmitResponse struct {
+	ShapeHash string
+}
+
+type OpStatusRequest struct {
+	ShapeHash string
+}
+
+type OpStatusResponse struct {
+	State OpState
+	// Block holding the operation (OP_INCLUDED only)
+	BlockHash string
+	// Number of blocks after BlockHash on the longest chain (OP_INCLUDED only)
+	Confirmations int
+	// Status code and message of the validation error (OP_REJECTED only)
+	Reason string
+	// Ink of the miner after the operation (OP_INCLUDED only)
+	InkRemaining uint32
+}
+
+// Contains the shape hash of an operation that was not confirmed in time
+type OperationTimeoutError string
+
+func (e OperationTimeoutError) Error() string {
+	return fmt.Sprintf("BlockArt: Operation not confirmed in time [%s]", string(e))
+}
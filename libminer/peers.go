@@ -0,0 +1,31 @@
+/*
+
+Request and response types for the admin calls LibMinerInterface.ListPeers
+and UnbanPeer. UnbanPeer replies with a bool telling whether the peer was
+banned.
+
+*/
+
+package libminer
+
+import "time"
+
+// A peer that is connected, banned or has misbehaved
+type PeerInfo struct {
+	Addr      string
+	Connected bool
+	// Misbehaviour score; the peer is banned when it reaches 0
+	Score       int
+	Banned      bool
+	BannedUntil time.Time
+	// Latest penalties, oldest first
+	Violations []string
+}
+
+type PeersResponse struct {
+	Peers []PeerInfo
+}
+
+type UnbanRequest struct {
+	Addr string
+}
@@ -0,0 +1,40 @@
+/*
+
+Request and response types for LibMinerInterface.GetShapesInRegion and
+GetShapesAtPoint, which look up the shapes on the current canvas by area.
+
+*/
+
+package libminer
+
+// Rectangle on the canvas. All bounds are inclusive.
+type Rect struct {
+	XMin int
+	YMin int
+	XMax int
+	YMax int
+}
+
+type RegionRequest struct {
+	Region Rect
+}
+
+type PointRequest struct {
+	X int
+	Y int
+}
+
+// A live shape and the bounding box of the pixels it covers
+type RegionShape struct {
+	CanvasShape
+	Bounds Rect
+}
+
+type RegionResponse struct {
+	// Tip of the longest chain the canvas was read at
+	BlockHash string
+	// For GetShapesInRegion, every shape covering at least one pixel of the
+	// region, in the order they were added. For GetShapesAtPoint, the shapes
+	// covering the pixel, topmost (last added) first.
+	Shapes []RegionShape
+}
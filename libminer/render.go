@@ -0,0 +1,21 @@
+/*
+
+Request and response types for LibMinerInterface.RenderPNG.
+
+*/
+
+package libminer
+
+type RenderRequest struct {
+	// Block to render the canvas at. Empty means the tip of the longest chain.
+	BlockHash string
+	// Longest side of the returned image in pixels. 0 means full size.
+	MaxSize int
+}
+
+type RenderResponse struct {
+	// The block that was rendered
+	BlockHash string
+	// PNG encoded image
+	PNG []byte
+}
@@ -0,0 +1,18 @@
+/*
+
+Request type for LibMinerInterface.Update and SubmitUpdate. Update replies
+with a DrawResponse and SubmitUpdate with a SubmitResponse; the ShapeHash in
+either is the hash of the new shape, which replaces the one in the request.
+
+*/
+
+package libminer
+
+type UpdateRequest struct {
+	// Shape being replaced, owned by the caller
+	ShapeHash   string
+	SVGString   string
+	Fill        string
+	Stroke      string
+	ValidateNum uint8
+}
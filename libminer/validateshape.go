@@ -0,0 +1,33 @@
+/*
+
+Request and response types for LibMinerInterface.ValidateShape, which checks
+a shape against the current canvas without submitting it.
+
+*/
+
+package libminer
+
+type ValidateShapeRequest struct {
+	SVGString string
+	Fill      string
+	Stroke    string
+}
+
+type ValidateShapeResponse struct {
+	// Tip of the longest chain the shape was checked against
+	BlockHash string
+	// Empty if Draw would accept the shape right now. Otherwise the error
+	// Draw would fail with, in the same "<code> <message>" form.
+	Error string
+	// Ink the shape costs, and the ink the caller has left before drawing it.
+	// Both are 0 if the shape can't be parsed.
+	InkCost      uint32
+	InkRemaining uint32
+	// Hashes of the shapes of other owners the new shape overlaps, in the
+	// order they were added
+	Conflicts []string
+	// Bounding box of the overlapping pixels, and how many there are. The
+	// box is meaningless when OverlapPixels is 0.
+	Overlap       Rect
+	OverlapPixels int
+}
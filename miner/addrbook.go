@@ -0,0 +1,423 @@
+/*
+
+This file contains the address book and the peer exchange between miners, so
+the network keeps finding peers while the registration server is down.
+
+The address book lists every miner address this miner has heard of, from the
+server, from the seed list in its config, or from its peers, with the last
+time it was connected to and the key it proved it owns. It is saved to
+ADDRESS_BOOK_FILE in the data directory and loaded on start, so a restarted
+miner can go back to the peers it knew. Addresses not seen for ADDRESS_TTL
+are dropped.
+
+Every so often, and whenever it is short of peers, a miner asks its peers for
+the addresses they have seen recently (Peer.GetAddrs). When the server can't
+supply enough peers, the miner connects to the most recently seen addresses
+in its book instead.
+
+A peer's key is normally checked against the key it registered with the
+server. When the server can't answer, the key in the address book is used:
+the one the address proved it owns on an earlier connection, or the one the
+seed list gave for it. Seeds can give their key as ip:port@<hex key>. Keys
+sent by peers in GetAddrs are never taken, so a peer can't pin a key for an
+address it doesn't own. An address with no known key isn't connected to.
+
+Peer RPC calls:
+  GetAddrs(args GetAddrsArgs, reply *[]KnownAddress)
+
+*/
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"../utils"
+)
+
+const (
+	ADDRESS_BOOK_FILE = "peers.json"
+	// Most addresses kept in the address book
+	MAX_ADDRESS_BOOK = 1024
+	// Most addresses sent in or taken from one GetAddrs reply
+	MAX_PEX_ADDRESSES = 64
+	// Addresses not seen for this long are dropped
+	ADDRESS_TTL = 7 * 24 * time.Hour
+	// Least time between two rounds of looking for peers
+	DISCOVERY_INTERVAL = 5 * time.Second
+)
+
+// Where an address was learned from
+const (
+	SOURCE_SERVER = "server"
+	SOURCE_SEED   = "seed"
+	SOURCE_PEER   = "peer"
+)
+
+type KnownAddress struct {
+	Addr string
+	// Key the miner at Addr is expected to own, as in
+	// utils.GetPublicKeyString: the one it proved it owns when last
+	// connected to, or the one its seed entry gave. Empty if neither.
+	Key string
+	// Last time it was connected to, zero if never
+	LastSeen time.Time
+	Source   string
+}
+
+type GetAddrsArgs struct {
+	From string
+	// Caps the reply below MAX_PEX_ADDRESSES if > 0
+	Max int
+}
+
+type AddressBook struct {
+	sync.Mutex
+	path    string
+	entries map[string]*KnownAddress
+	dirty   bool
+}
+
+// The address book of this miner, loaded in startMiner
+var Book = NewAddressBook("")
+
+// When peers were last looked for
+var lastDiscovery time.Time
+
+// Returns an empty address book saved to path. An empty path is never saved.
+func NewAddressBook(path string) *AddressBook {
+	return &AddressBook{path: path, entries: make(map[string]*KnownAddress)}
+}
+
+// Loads the address book at path, or starts an empty one if there is none
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := NewAddressBook(path)
+
+	buffer, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book, nil
+	}
+	if err != nil {
+		return book, err
+	}
+
+	var entries []KnownAddress
+	if err = json.Unmarshal(buffer, &entries); err != nil {
+		return book, fmt.Errorf("parse address book %s: %s", path, err)
+	}
+	for i := range entries {
+		// Books saved by older versions took keys from peers. Only keep
+		// keys that were proven or configured.
+		if entries[i].Source == SOURCE_PEER && entries[i].LastSeen.IsZero() {
+			entries[i].Key = ""
+		}
+		book.entries[entries[i].Addr] = &entries[i]
+	}
+	book.prune()
+	return book, nil
+}
+
+// Writes the address book out if it changed since the last save
+func (b *AddressBook) Save() error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.path == "" || !b.dirty {
+		return nil
+	}
+
+	bytes, err := json.MarshalIndent(b.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave half a book
+	tmp := b.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bytes, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, b.path); err != nil {
+		return err
+	}
+	b.dirty = false
+	return nil
+}
+
+// Adds addr if it isn't known yet. key is only taken if none is known, and
+// must come from the server or the seed list, never from a peer.
+func (b *AddressBook) Add(addr, key, source string) {
+	if !validAddress(addr) || addr == MinerInstance.Addr.String() {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	entry, ok := b.entries[addr]
+	if !ok {
+		entry = &KnownAddress{Addr: addr, Source: source}
+		b.entries[addr] = entry
+		b.dirty = true
+	}
+	if entry.Key == "" && key != "" {
+		entry.Key = key
+		b.dirty = true
+	}
+	b.prune()
+}
+
+// Records that addr was just connected to and proved it owns pubKey
+func (b *AddressBook) Seen(addr string, pubKey *ecdsa.PublicKey) {
+	b.Lock()
+	defer b.Unlock()
+
+	entry, ok := b.entries[addr]
+	if !ok {
+		entry = &KnownAddress{Addr: addr, Source: SOURCE_PEER}
+		b.entries[addr] = entry
+	}
+	entry.LastSeen = time.Now()
+	if pubKey != nil {
+		entry.Key = utils.GetPublicKeyString(*pubKey)
+	}
+	b.dirty = true
+	b.prune()
+}
+
+// Returns the key addr proved it owns when last connected to, or the one
+// its seed entry gave if it never was. nil if neither is known.
+func (b *AddressBook) Key(addr string) *ecdsa.PublicKey {
+	b.Lock()
+	defer b.Unlock()
+
+	entry, ok := b.entries[addr]
+	if !ok || entry.Key == "" {
+		return nil
+	}
+	pubKey, err := parsePublicKey(entry.Key)
+	if err != nil {
+		return nil
+	}
+	return pubKey
+}
+
+// Returns up to n addresses for which skip is false, most recently seen
+// first
+func (b *AddressBook) Candidates(n int, skip func(addr string) bool) []string {
+	b.Lock()
+	defer b.Unlock()
+
+	addrs := make([]string, 0, n)
+	for _, entry := range b.list() {
+		if len(addrs) == n {
+			break
+		}
+		if !skip(entry.Addr) {
+			addrs = append(addrs, entry.Addr)
+		}
+	}
+	return addrs
+}
+
+// Returns up to n addresses that have been seen, most recent first
+func (b *AddressBook) Recent(n int) []KnownAddress {
+	b.Lock()
+	defer b.Unlock()
+
+	recent := make([]KnownAddress, 0, n)
+	for _, entry := range b.list() {
+		if len(recent) == n || entry.LastSeen.IsZero() {
+			break
+		}
+		recent = append(recent, entry)
+	}
+	return recent
+}
+
+// Returns the entries, most recently seen first. Must be called with b
+// locked.
+func (b *AddressBook) list() []KnownAddress {
+	entries := make([]KnownAddress, 0, len(b.entries))
+	for _, entry := range b.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastSeen.Equal(entries[j].LastSeen) {
+			return entries[i].LastSeen.After(entries[j].LastSeen)
+		}
+		return entries[i].Addr < entries[j].Addr
+	})
+	return entries
+}
+
+// Drops addresses not seen for ADDRESS_TTL, and the least recently seen ones
+// beyond MAX_ADDRESS_BOOK. Addresses never seen are kept until the book is
+// full. Must be called with b locked.
+func (b *AddressBook) prune() {
+	for addr, entry := range b.entries {
+		if !entry.LastSeen.IsZero() && time.Since(entry.LastSeen) > ADDRESS_TTL {
+			delete(b.entries, addr)
+			b.dirty = true
+		}
+	}
+
+	if len(b.entries) > MAX_ADDRESS_BOOK {
+		entries := b.list()
+		// Never seen addresses sort last, so they go first
+		for _, entry := range entries[MAX_ADDRESS_BOOK:] {
+			delete(b.entries, entry.Addr)
+		}
+		b.dirty = true
+	}
+}
+
+/*******************************
+| Peer exchange
+********************************/
+
+// Returns the addresses this miner has seen recently, itself included
+func (p *PeerRpc) GetAddrs(args GetAddrsArgs, reply *[]KnownAddress) error {
+	if err := p.checkFrom(args.From); err != nil {
+		return err
+	}
+
+	max := MAX_PEX_ADDRESSES
+	if args.Max > 0 && args.Max < max {
+		max = args.Max
+	}
+
+	self := KnownAddress{
+		Addr:     MinerInstance.Addr.String(),
+		Key:      utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey),
+		LastSeen: time.Now(),
+		Source:   SOURCE_PEER}
+
+	addrs := append([]KnownAddress{self}, Book.Recent(max-1)...)
+	for i := range addrs {
+		// Where we learned it from is of no use to the peer
+		addrs[i].Source = SOURCE_PEER
+	}
+	*reply = addrs
+	return nil
+}
+
+// Asks every peer, on its own goroutine, for the addresses it has seen and
+// adds them to the book. Called from ManageConnections.
+func ExchangeAddresses() {
+	from := MinerInstance.Addr.String()
+
+	PeerList.SendAll(func(peer *Peer) {
+		var addrs []KnownAddress
+		err := peer.Call("Peer.GetAddrs", GetAddrsArgs{From: from}, &addrs, CALL_TIMEOUT)
+		if CheckError(err, "ExchangeAddresses:"+peer.Addr) {
+			return
+		}
+
+		if len(addrs) > MAX_PEX_ADDRESSES {
+			Penalize(peer.Addr, PENALTY_OVERSIZED, fmt.Sprintf("sent %d addresses", len(addrs)))
+			return
+		}
+		// The keys the peer sent are unproven, so only the addresses are
+		// taken. Their keys are learned from the server or on connecting.
+		for _, known := range addrs {
+			Book.Add(known.Addr, "", SOURCE_PEER)
+		}
+		CheckError(Book.Save(), "ExchangeAddresses:Save")
+	})
+}
+
+// Looks for more peers when there are fewer than the network asks for: from
+// the server first, then from the address book, then by asking the peers we
+// have for theirs. Runs at most once every DISCOVERY_INTERVAL. Called from
+// ManageConnections.
+func DiscoverPeers() {
+	wanted := int(MinerInstance.Settings.MinNumMinerConnections)
+	if PeerList.Count() >= wanted || time.Since(lastDiscovery) < DISCOVERY_INTERVAL {
+		return
+	}
+	lastDiscovery = time.Now()
+
+	var addrSet []net.Addr
+	err := MinerInstance.MSI.call("RServer.GetNodes", MinerInstance.PrivKey.PublicKey, &addrSet)
+	if !CheckError(err, "DiscoverPeers:GetNodes") {
+		for _, addr := range addrSet {
+			Book.Add(addr.String(), "", SOURCE_SERVER)
+		}
+		MinerInstance.MSI.GetPeers(addrSet)
+	}
+
+	// Connections are set up in the background, so count the pending ones
+	if PeerList.Count() < wanted {
+		skip := func(addr string) bool {
+			_, connected := PeerList.Get(addr)
+			return connected || IsBanned(addr)
+		}
+		for _, addr := range Book.Candidates(wanted-PeerList.Count(), skip) {
+			tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+			if CheckError(err, "DiscoverPeers:Resolve") {
+				continue
+			}
+			MinerInstance.MSI.GetPeers([]net.Addr{tcpAddr})
+		}
+	}
+
+	if PeerList.Len() < wanted {
+		ExchangeAddresses()
+	}
+	CheckError(Book.Save(), "DiscoverPeers:Save")
+}
+
+// Returns the key addr has to prove it owns: the one it registered with the
+// server, or if the server can't tell, the one in the address book. nil if
+// neither knows it.
+func expectedKey(addr string) *ecdsa.PublicKey {
+	key, err := MinerInstance.MSI.RegisteredKey(addr)
+	if err == nil {
+		return key
+	}
+
+	Logf(LOG_INFO, "expectedKey:: server can't give the key of %s (%s), using the address book", addr, err)
+	return Book.Key(addr)
+}
+
+// Splits a seed given as ip:port or ip:port@<hex key>
+func parseSeed(seed string) (addr, key string) {
+	seed = strings.TrimSpace(seed)
+	if i := strings.LastIndex(seed, "@"); i >= 0 {
+		return seed[:i], seed[i+1:]
+	}
+	return seed, ""
+}
+
+func validAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	return err == nil && host != "" && port != "" && port != "0"
+}
+
+func parsePublicKey(key string) (*ecdsa.PublicKey, error) {
+	bytes, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(bytes)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not an ECDSA key")
+	}
+	return pubKey, nil
+}
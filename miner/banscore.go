@@ -0,0 +1,196 @@
+/*
+
+This file contains the misbehaviour scores of peers.
+
+Every peer starts with INITIAL_PEER_SCORE. Invalid proof of work, invalid
+blocks and ops, oversized messages and unrequested payloads cost it points;
+a point comes back every SCORE_RECOVERY_INTERVAL. A peer that runs out of
+points is banned for BAN_DURATION: it is dropped from PeerList, its calls are
+refused and it isn't connected to again until the ban expires or an
+operator lifts it with the UnbanPeer call.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"../libminer"
+)
+
+const (
+	INITIAL_PEER_SCORE      = 100
+	BAN_DURATION            = 1 * time.Hour
+	SCORE_RECOVERY_INTERVAL = 1 * time.Minute
+	// Most peers kept in PeerList
+	MAX_PEERS = 32
+	// Most violations remembered per peer
+	MAX_VIOLATIONS = 8
+)
+
+// Points a peer loses for each kind of misbehaviour
+const (
+	PENALTY_INVALID_POW   = 50
+	PENALTY_INVALID_BLOCK = 20
+	PENALTY_INVALID_OP    = 10
+	PENALTY_OVERSIZED     = 25
+	PENALTY_SPAM          = 5
+)
+
+// Contains the address of a banned peer
+type BannedPeerError string
+
+func (e BannedPeerError) Error() string {
+	return fmt.Sprintf("Banned peer: %s", string(e))
+}
+
+type peerScore struct {
+	score       int
+	updated     time.Time
+	bannedUntil time.Time
+	connected   bool
+	violations  []string
+}
+
+var peerScores = struct {
+	sync.Mutex
+	entries map[string]*peerScore
+}{entries: make(map[string]*peerScore)}
+
+// Returns the entry for addr with recovered points added. Must be called
+// with peerScores locked.
+func scoreOf(addr string) *peerScore {
+	s, ok := peerScores.entries[addr]
+	if !ok {
+		s = &peerScore{score: INITIAL_PEER_SCORE, updated: time.Now()}
+		peerScores.entries[addr] = s
+	}
+
+	if recovered := int(time.Since(s.updated) / SCORE_RECOVERY_INTERVAL); recovered > 0 {
+		s.score += recovered
+		if s.score > INITIAL_PEER_SCORE {
+			s.score = INITIAL_PEER_SCORE
+		}
+		s.updated = s.updated.Add(time.Duration(recovered) * SCORE_RECOVERY_INTERVAL)
+	}
+	return s
+}
+
+// Takes penalty points from addr and bans it if it runs out
+func Penalize(addr string, penalty int, reason string) {
+	peerScores.Lock()
+	defer peerScores.Unlock()
+
+	s := scoreOf(addr)
+	s.score -= penalty
+	s.violations = append(s.violations, fmt.Sprintf("%s -%d %s", time.Now().Format(time.RFC3339), penalty, reason))
+	if len(s.violations) > MAX_VIOLATIONS {
+		s.violations = s.violations[len(s.violations)-MAX_VIOLATIONS:]
+	}
+
+	Logf(LOG_INFO, "Penalize:: %s -%d for %s, score %d", addr, penalty, reason, s.score)
+	if s.score <= 0 && !time.Now().Before(s.bannedUntil) {
+		s.bannedUntil = time.Now().Add(BAN_DURATION)
+		Logf(LOG_INFO, "Penalize:: banning %s until %s", addr, s.bannedUntil.Format(time.RFC3339))
+	}
+}
+
+func IsBanned(addr string) bool {
+	peerScores.Lock()
+	defer peerScores.Unlock()
+
+	s, ok := peerScores.entries[addr]
+	if !ok || !time.Now().Before(s.bannedUntil) {
+		return false
+	}
+	return true
+}
+
+// Lifts the ban on addr and restores its score. Returns false if it wasn't
+// banned.
+func Unban(addr string) bool {
+	peerScores.Lock()
+	defer peerScores.Unlock()
+
+	s, ok := peerScores.entries[addr]
+	if !ok {
+		return false
+	}
+
+	wasBanned := time.Now().Before(s.bannedUntil)
+	s.score = INITIAL_PEER_SCORE
+	s.updated = time.Now()
+	s.bannedUntil = time.Time{}
+	return wasBanned
+}
+
+// Records whether addr is in PeerList
+func setPeerConnected(addr string, connected bool) {
+	peerScores.Lock()
+	scoreOf(addr).connected = connected
+	peerScores.Unlock()
+}
+
+// Returns every peer that is connected, banned or has lost points, by address
+func PeerInfos() []libminer.PeerInfo {
+	peerScores.Lock()
+	defer peerScores.Unlock()
+
+	infos := make([]libminer.PeerInfo, 0, len(peerScores.entries))
+	for addr := range peerScores.entries {
+		s := scoreOf(addr)
+		banned := time.Now().Before(s.bannedUntil)
+		if !s.connected && !banned && s.score == INITIAL_PEER_SCORE {
+			continue
+		}
+
+		info := libminer.PeerInfo{
+			Addr:       addr,
+			Connected:  s.connected,
+			Score:      s.score,
+			Banned:     banned,
+			Violations: append([]string{}, s.violations...)}
+		if banned {
+			info.BannedUntil = s.bannedUntil
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Addr < infos[j].Addr })
+	return infos
+}
+
+/*******************************
+| Admin RPC functions
+********************************/
+
+// Lists the peers with their scores and bans
+func (lmi *LibMinerInterface) ListPeers(req *libminer.Request, response *libminer.PeersResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		response.Peers = PeerInfos()
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Lifts the ban on a peer. Replies whether it was banned.
+func (lmi *LibMinerInterface) UnbanPeer(req *libminer.Request, response *bool) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var unbanReq libminer.UnbanRequest
+		json.Unmarshal(req.Msg, &unbanReq)
+
+		*response = Unban(unbanReq.Addr)
+		Logf(LOG_INFO, "UnbanPeer:: %s unbanned: %t", unbanReq.Addr, *response)
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
@@ -0,0 +1,132 @@
+/*
+
+This file contains the canvas diff call for art nodes. Both canvases are
+reconstructed with CanvasShapes and compared by shape hash, so the two blocks
+can be on different forks.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"../libminer"
+	"../shapelib"
+)
+
+// Returns the shapes added and deleted between two blocks
+func (lmi *LibMinerInterface) GetCanvasDiff(req *libminer.Request, response *libminer.CanvasDiffResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var diffReq libminer.CanvasDiffRequest
+		json.Unmarshal(req.Msg, &diffReq)
+
+		diff, err := CanvasDiff(diffReq.FromBlockHash, diffReq.ToBlockHash)
+		if err != nil {
+			return errors.New(CheckStatusCode(err))
+		}
+
+		*response = diff
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Compares the canvas at fromHash (empty for the genesis block) with the one
+// at toHash (empty for the tip of the longest chain). Possible Errors:
+// - InvalidBlockHashError
+func CanvasDiff(fromHash, toHash string) (diff libminer.CanvasDiffResponse, err error) {
+	fromChain, err := ChainAt(fromHash, 0)
+	if err != nil {
+		return diff, err
+	}
+	toChain, err := ChainAt(toHash, -1)
+	if err != nil {
+		return diff, err
+	}
+
+	fromHashes, toHashes := ChainHashes(fromChain), ChainHashes(toChain)
+	diff.FromBlockHash = fromHashes[len(fromHashes)-1]
+	diff.ToBlockHash = toHashes[len(toHashes)-1]
+	diff.AncestorHash = fromHashes[commonAncestor(fromHashes, toHashes)]
+
+	fromShapes := CanvasShapes(fromChain, fromHashes)
+	toShapes := CanvasShapes(toChain, toHashes)
+
+	fromSet := make(map[string]bool)
+	for _, shape := range fromShapes {
+		fromSet[shape.ShapeHash] = true
+	}
+	toSet := make(map[string]bool)
+	for _, shape := range toShapes {
+		toSet[shape.ShapeHash] = true
+	}
+
+	diff.Added = make([]libminer.CanvasShape, 0)
+	for _, shape := range toShapes {
+		if !fromSet[shape.ShapeHash] {
+			diff.Added = append(diff.Added, shape)
+		}
+	}
+	diff.Deleted = make([]libminer.CanvasShape, 0)
+	for _, shape := range fromShapes {
+		if !toSet[shape.ShapeHash] {
+			diff.Deleted = append(diff.Deleted, shape)
+		}
+	}
+
+	diff.Region = diffRegion(append(append([]libminer.CanvasShape{}, diff.Added...), diff.Deleted...))
+	return diff, nil
+}
+
+// Returns the bounding box and pixel count of the area covered by shapes
+func diffRegion(shapes []libminer.CanvasShape) libminer.DiffRegion {
+	settings := MinerInstance.Settings.CanvasSettings
+	width, height := int(settings.CanvasXMax)+1, int(settings.CanvasYMax)+1
+	covered := make([]bool, width*height)
+
+	region := libminer.DiffRegion{Empty: true}
+	for _, canvasShape := range shapes {
+		opShapes, err := MinerInstance.getShapesFromOp(canvasShape.Op)
+		if err != nil {
+			continue
+		}
+
+		for _, shape := range opShapes {
+			markPixels(shape.SubArray(), width, height, covered, &region)
+		}
+	}
+	return region
+}
+
+// Marks the pixels of sub in covered and grows region to include them
+func markPixels(sub shapelib.PixelSubArray, width, height int, covered []bool, region *libminer.DiffRegion) {
+	sub.ForEachPixel(func(x, y int) {
+		if x < 0 || y < 0 || x >= width || y >= height || covered[y*width+x] {
+			return
+		}
+		covered[y*width+x] = true
+		region.Pixels++
+
+		if region.Empty {
+			*region = libminer.DiffRegion{XMin: x, YMin: y, XMax: x, YMax: y, Pixels: region.Pixels}
+			return
+		}
+		if x < region.XMin {
+			region.XMin = x
+		}
+		if x > region.XMax {
+			region.XMax = x
+		}
+		if y < region.YMin {
+			region.YMin = y
+		}
+		if y > region.YMax {
+			region.YMax = y
+		}
+	})
+}
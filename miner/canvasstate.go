@@ -0,0 +1,118 @@
+/*
+
+This file contains the canvas time-travel call for art nodes: the set of
+shapes that were live at a given block, with their owners and costs.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"../blockchain"
+	"../libminer"
+)
+
+// Returns the canvas at CanvasStateRequest.BlockHash, or at the requested
+// height on the longest chain.
+func (lmi *LibMinerInterface) GetCanvasState(req *libminer.Request, response *libminer.CanvasStateResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var stateReq libminer.CanvasStateRequest
+		json.Unmarshal(req.Msg, &stateReq)
+
+		state, err := CanvasStateAt(stateReq.BlockHash, stateReq.Height)
+		if err != nil {
+			return errors.New(CheckStatusCode(err))
+		}
+
+		*response = state
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Reconstructs the canvas at blockHash or, if it is empty, at height on the
+// longest chain (negative for the tip). Possible Errors:
+// - InvalidBlockHashError
+// - InvalidBlockHeightError
+func CanvasStateAt(blockHash string, height int) (state libminer.CanvasStateResponse, err error) {
+	chain, err := ChainAt(blockHash, height)
+	if err != nil {
+		return state, err
+	}
+
+	hashes := ChainHashes(chain)
+	state.BlockHash = hashes[len(hashes)-1]
+	state.Height = len(chain) - 1
+	state.Shapes = CanvasShapes(chain, hashes)
+	return state, nil
+}
+
+// Returns the path from the genesis block to blockHash or, if it is empty, to
+// height on the longest chain (negative for the tip).
+func ChainAt(blockHash string, height int) ([]blockchain.Block, error) {
+	if blockHash != "" {
+		return ConnectedPath(blockHash)
+	}
+
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	if height < 0 {
+		return chain, nil
+	}
+	if height >= len(chain) {
+		return nil, libminer.InvalidBlockHeightError(height)
+	}
+	return chain[:height+1], nil
+}
+
+// Replays chain and returns the shapes still live at its end, in the order
+// they were added. hashes are the block hashes of chain (see ChainHashes).
+func CanvasShapes(chain []blockchain.Block, hashes []string) []libminer.CanvasShape {
+	order := make([]string, 0)
+	live := make(map[string]libminer.CanvasShape)
+	// Full cost of every shape added, refunded when it is replaced
+	shapeCosts := make(map[string]int)
+
+	for i, block := range chain {
+		for _, opInfo := range block.OpHistory {
+			// An UPDATE replaces the shape at AddSig with a new one
+			if opInfo.Op.OpType == blockchain.DELETE || opInfo.Op.OpType == blockchain.UPDATE {
+				delete(live, opInfo.AddSig)
+			}
+			if opInfo.Op.OpType == blockchain.DELETE {
+				continue
+			}
+
+			cost, _ := MinerInstance.opCost(opInfo.Op)
+			shapeCosts[opInfo.OpSig] = cost
+
+			// Charged the same way as in CalculateInk
+			charged := cost
+			if opInfo.Op.OpType == blockchain.UPDATE {
+				charged -= shapeCosts[opInfo.AddSig]
+			}
+
+			order = append(order, opInfo.OpSig)
+			live[opInfo.OpSig] = libminer.CanvasShape{
+				ShapeHash:   opInfo.OpSig,
+				Owner:       opInfo.PubKey,
+				InkCost:     charged,
+				BlockHash:   hashes[i],
+				BlockHeight: i,
+				Op:          opInfo.Op}
+		}
+	}
+
+	shapes := make([]libminer.CanvasShape, 0, len(live))
+	for _, shapeHash := range order {
+		if shape, ok := live[shapeHash]; ok {
+			shapes = append(shapes, shape)
+		}
+	}
+	return shapes
+}
@@ -0,0 +1,251 @@
+/*
+
+This file contains compact block relay.
+
+Peers have usually seen the ops of a new block already, gossiped on their
+own. So when a block is delivered to a peer that supports compact blocks,
+only the header is sent with a short ID per op: the first SHORT_ID_LEN hex
+digits of the md5 of the op's signature, salted with the block's header so
+IDs can't be made to collide across blocks. The receiver rebuilds the block
+from the ops in its pool (the ops it accepted recently), and answers Deliver
+with the positions of the ops it is missing. The sender sends those with
+Peer.BlockOps. If the rebuilt block doesn't hash to the announced hash (two
+ops shared a short ID), the receiver asks for the full block instead.
+
+Blocks without ops are always sent in full, there is nothing to save.
+
+Peer RPC calls:
+  BlockOps(args BlockOpsArgs, reply *bool)
+
+*/
+
+package miner
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+
+	"../blockchain"
+)
+
+const (
+	// Hex digits of a short op ID
+	SHORT_ID_LEN = 12
+	// Ops kept to rebuild compact blocks from
+	OP_POOL_SIZE = 4096
+	// Compact blocks kept while their missing ops are fetched
+	PENDING_COMPACT_SIZE = 64
+)
+
+// A block without its ops
+type CompactBlock struct {
+	// Hash the block is announced under, checked once it is rebuilt
+	Hash        string
+	PrevHash    string
+	MinerPubKey string
+	Nonce       uint32
+	// Short ID of each op of OpHistory, in order
+	ShortIDs []string
+}
+
+type DeliverReply struct {
+	// Key: hash of a compact block. Val: positions of the ops the receiver
+	// is missing, to be sent with BlockOps.
+	Missing map[string][]int
+	// Hashes of the compact blocks that have to be sent in full
+	Full []string
+}
+
+type BlockOpsArgs struct {
+	From string
+	Hash string
+	// The ops asked for in DeliverReply.Missing, in the same order
+	Ops []blockchain.OperationInfo
+}
+
+// A compact block waiting for its missing ops
+type pendingBlock struct {
+	from    string
+	compact CompactBlock
+	ops     []blockchain.OperationInfo
+	missing []int
+}
+
+var (
+	// Key: OpSig. Val: blockchain.OperationInfo accepted by this miner.
+	opPool = NewLRUCache(OP_POOL_SIZE)
+	// Key: block hash. Val: *pendingBlock.
+	pendingCompact = NewLRUCache(PENDING_COMPACT_SIZE)
+)
+
+// Returns the short ID of the op signed opSig in a block with header salt
+func ShortOpID(salt, opSig string) string {
+	h := md5.Sum([]byte(salt + opSig))
+	return hex.EncodeToString(h[:])[:SHORT_ID_LEN]
+}
+
+// Returns the compact form of block, which hashes to hash
+func NewCompactBlock(hash string, block blockchain.Block) CompactBlock {
+	compact := CompactBlock{
+		Hash:        hash,
+		PrevHash:    block.PrevHash,
+		MinerPubKey: block.MinerPubKey,
+		Nonce:       block.Nonce,
+		ShortIDs:    make([]string, len(block.OpHistory))}
+
+	salt := compact.salt()
+	for i, opInfo := range block.OpHistory {
+		compact.ShortIDs[i] = ShortOpID(salt, opInfo.OpSig)
+	}
+	return compact
+}
+
+func (c CompactBlock) salt() string {
+	return c.PrevHash + c.MinerPubKey + strconv.FormatUint(uint64(c.Nonce), 10)
+}
+
+// Fills in the ops of c found in the op pool. Returns the ops, with zero
+// values where they are missing, and the positions of the missing ones.
+func (c CompactBlock) rebuild() (ops []blockchain.OperationInfo, missing []int) {
+	salt := c.salt()
+
+	// Short IDs two pool ops share are treated as missing
+	byID := make(map[string]blockchain.OperationInfo)
+	shared := make(map[string]bool)
+	for _, value := range opPool.Values() {
+		opInfo := value.(blockchain.OperationInfo)
+		id := ShortOpID(salt, opInfo.OpSig)
+		if _, ok := byID[id]; ok {
+			shared[id] = true
+		}
+		byID[id] = opInfo
+	}
+
+	ops = make([]blockchain.OperationInfo, len(c.ShortIDs))
+	missing = make([]int, 0)
+	for i, id := range c.ShortIDs {
+		opInfo, ok := byID[id]
+		if !ok || shared[id] {
+			missing = append(missing, i)
+			continue
+		}
+		ops[i] = opInfo
+	}
+	return ops, missing
+}
+
+func (c CompactBlock) block(ops []blockchain.OperationInfo) blockchain.Block {
+	return blockchain.Block{
+		PrevHash:    c.PrevHash,
+		OpHistory:   ops,
+		MinerPubKey: c.MinerPubKey,
+		Nonce:       c.Nonce}
+}
+
+// Handles a compact block delivered by from. Blocks that can be rebuilt
+// right away are taken like full ones; the others are recorded in reply.
+func (p *PeerRpc) receiveCompact(from string, compact CompactBlock, reply *DeliverReply) {
+	// The claimed hash has to meet the difficulty before any work is done
+	if !verifyHash(compact.Hash, len(compact.ShortIDs)) {
+		Penalize(from, PENALTY_INVALID_POW, "invalid proof of work "+compact.Hash)
+		return
+	}
+
+	ops, missing := compact.rebuild()
+	if len(missing) > 0 {
+		pendingCompact.Add(compact.Hash, &pendingBlock{from, compact, ops, missing})
+		reply.Missing[compact.Hash] = missing
+		return
+	}
+
+	block := compact.block(ops)
+	if GetBlockHash(block) != compact.Hash {
+		reply.Full = append(reply.Full, compact.Hash)
+		return
+	}
+	takeRequested(from, compact.Hash)
+	p.acceptBlock(from, block)
+}
+
+// Receives the missing ops of a compact block delivered earlier. Replies
+// false if the block has to be sent in full.
+func (p *PeerRpc) BlockOps(args BlockOpsArgs, reply *bool) error {
+	if err := p.checkFrom(args.From); err != nil {
+		return err
+	}
+	if IsBanned(args.From) {
+		return BannedPeerError(args.From)
+	}
+
+	value, ok := pendingCompact.Get(args.Hash)
+	if !ok || value.(*pendingBlock).from != args.From {
+		Penalize(args.From, PENALTY_SPAM, "unrequested block ops "+args.Hash)
+		return fmt.Errorf("BlockOps: no compact block %s pending from %s", args.Hash, args.From)
+	}
+	pending := value.(*pendingBlock)
+	pendingCompact.Remove(args.Hash)
+
+	if len(args.Ops) != len(pending.missing) {
+		Penalize(args.From, PENALTY_INVALID_BLOCK, "wrong number of block ops "+args.Hash)
+		return fmt.Errorf("BlockOps: got %d ops, asked for %d", len(args.Ops), len(pending.missing))
+	}
+	for i, index := range pending.missing {
+		pending.ops[index] = args.Ops[i]
+	}
+
+	block := pending.compact.block(pending.ops)
+	if GetBlockHash(block) != args.Hash {
+		*reply = false
+		return nil
+	}
+
+	takeRequested(args.From, args.Hash)
+	p.acceptBlock(args.From, block)
+	*reply = true
+	return nil
+}
+
+// Sends peer what it asked for in reply to compact blocks: the missing ops,
+// and the blocks it couldn't rebuild in full. blocks holds the blocks that
+// were sent, by hash.
+func completeCompactBlocks(peer *Peer, from string, blocks map[string]blockchain.Block, reply DeliverReply) {
+	full := make([]blockchain.Block, 0)
+	for _, hash := range reply.Full {
+		if block, ok := blocks[hash]; ok {
+			full = append(full, block)
+		}
+	}
+
+	for hash, missing := range reply.Missing {
+		block, ok := blocks[hash]
+		if !ok {
+			continue
+		}
+
+		args := BlockOpsArgs{From: from, Hash: hash, Ops: make([]blockchain.OperationInfo, 0, len(missing))}
+		for _, index := range missing {
+			if index < 0 || index >= len(block.OpHistory) {
+				break
+			}
+			args.Ops = append(args.Ops, block.OpHistory[index])
+		}
+
+		var rebuilt bool
+		err := peer.Call("Peer.BlockOps", args, &rebuilt, CALL_TIMEOUT)
+		if CheckError(err, "completeCompactBlocks:"+peer.Addr) {
+			continue
+		}
+		if !rebuilt {
+			full = append(full, block)
+		}
+	}
+
+	if len(full) == 0 {
+		return
+	}
+	var ignored DeliverReply
+	err := peer.Call("Peer.Deliver", DeliverArgs{From: from, Blocks: full}, &ignored, CALL_TIMEOUT)
+	CheckError(err, "completeCompactBlocks:Deliver:"+peer.Addr)
+}
@@ -0,0 +1,338 @@
+/*
+
+This file contains the configuration layer for the miner.
+
+Settings are resolved in this order, later ones winning:
+1. Defaults (the old hard-coded behaviour)
+2. The JSON config file given with -c
+3. Command line flags
+
+Usage:
+
+$ go run ink-miner.go [flags] [server-ip:port [pubKey privKey]]
+  -c string
+    	Path to the JSON config
+  -server string
+    	Registration server ip:port
+  -listen string
+    	ip:port for miner-to-miner RPC (default: first non-loopback IPv4, random port)
+  -artnode-listen string
+    	ip:port for art node RPC (default ":0")
+  -artnode-allow-plaintext
+    	Also serve art nodes that don't connect over TLS, like the ones using
+    	the original OpenCanvas
+  -gateway string
+    	ip:port for the HTTP/JSON gateway (default: disabled)
+  -gateway-origin string
+    	Origin browsers may call the gateway from, or "*" for any
+    	(default: none, only same-origin pages and non-browser clients)
+  -seeds string
+    	Comma separated ip:port of miners to try when the server has no peers,
+    	each optionally followed by @<hex public key> so it can be checked
+    	while the server is down
+  -datadir string
+    	Directory for ip-ports.txt and debug output (default ".")
+  -keydir string
+    	Key store directory (default "./keys")
+  -key string
+    	Name of the key in the key store
+  -threads int
+    	Number of proof of work threads (default 2)
+  -optimeout int
+    	Seconds Draw and Delete wait for confirmations (default 600)
+  -loglevel string
+    	One of debug, info, error (default "info")
+
+The key store passphrase is passed to MineWithConfig rather than set in the
+config, so it doesn't end up in the config file or shell history. Entry
+points usually read it from the INKCOIN_PASSPHRASE environment variable
+(PASSPHRASE_ENV).
+
+Example config:
+
+{
+  "server": "127.0.0.1:12345",
+  "listen": "0.0.0.0:0",
+  "artnode-listen": "127.0.0.1:0",
+  "gateway-listen": "127.0.0.1:8080",
+  "gateway-origin": "http://127.0.0.1:8000",
+  "seeds": ["10.0.0.2:4000", "10.0.0.3:4000@3076301006..."],
+  "datadir": "./data",
+  "keydir": "./keys",
+  "key": "alice",
+  "threads": 4,
+  "op-timeout": 300,
+  "loglevel": "debug"
+}
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"../keystore"
+)
+
+// Environment variable holding the key store passphrase
+const PASSPHRASE_ENV = keystore.PASSPHRASE_ENV
+
+// Log levels, from most to least verbose
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_ERROR
+)
+
+var logLevels = map[string]int{
+	"debug": LOG_DEBUG,
+	"info":  LOG_INFO,
+	"error": LOG_ERROR,
+}
+
+type Config struct {
+	// Registration server ip:port
+	ServerAddr string `json:"server"`
+
+	// ip:port to listen on for other miners. Empty means pick the first
+	// non-loopback IPv4 address with a random port.
+	ListenAddr string `json:"listen"`
+
+	// ip:port to listen on for art nodes
+	ArtNodeListenAddr string `json:"artnode-listen"`
+
+	// Also serve art nodes that don't connect over TLS. Off by default, so
+	// art apps built on the original OpenCanvas need it turned on.
+	AllowArtNodePlaintext bool `json:"artnode-allow-plaintext"`
+
+	// ip:port for the HTTP/JSON gateway. Empty disables it.
+	GatewayListenAddr string `json:"gateway-listen"`
+
+	// Origin sent in Access-Control-Allow-Origin by the gateway, "*" for
+	// any. Empty sends no CORS headers, so only same-origin pages can call it.
+	GatewayOrigin string `json:"gateway-origin"`
+
+	// ip:port of miners to try when the server can't supply peers, each
+	// optionally followed by @<hex public key>, see addrbook.go
+	Seeds []string `json:"seeds"`
+
+	// Directory for ip-ports.txt, the address book and debug dumps
+	DataDir string `json:"datadir"`
+
+	// Key store directory and key name. Ignored if PubKey and PrivKey are set.
+	KeyDir  string `json:"keydir"`
+	KeyName string `json:"key"`
+
+	// Legacy hex key pair, as in the old key-pairs.txt files
+	PubKey  string `json:"-"`
+	PrivKey string `json:"-"`
+
+	// Number of proof of work threads
+	Threads int `json:"threads"`
+
+	// Seconds Draw and Delete wait for an op to be confirmed
+	OpTimeoutSec int `json:"op-timeout"`
+
+	// One of debug, info, error
+	LogLevel string `json:"loglevel"`
+}
+
+// The configuration the miner was started with
+var MinerConfig = DefaultConfig()
+
+// Returns the settings the miner used before it was configurable. The old
+// mining loop ran MAX_THREADS + 1 solvers.
+func DefaultConfig() Config {
+	return Config{
+		ArtNodeListenAddr: ":0",
+		DataDir:           ".",
+		KeyDir:            keystore.DEFAULT_DIR,
+		Threads:           MAX_THREADS + 1,
+		OpTimeoutSec:      600,
+		LogLevel:          "info"}
+}
+
+// Parses the config file and flags in args (usually os.Args[1:]).
+// Positional arguments keep the old "server pubKey privKey" form working.
+func LoadConfig(args []string) (config Config, err error) {
+	config = DefaultConfig()
+
+	fs := flag.NewFlagSet("ink-miner", flag.ContinueOnError)
+	path := fs.String("c", "", "Path to the JSON config")
+	server := fs.String("server", "", "Registration server ip:port")
+	listen := fs.String("listen", "", "ip:port for miner-to-miner RPC")
+	artNodeListen := fs.String("artnode-listen", "", "ip:port for art node RPC")
+	artNodePlaintext := fs.Bool("artnode-allow-plaintext", false, "Also serve art nodes that don't connect over TLS")
+	gateway := fs.String("gateway", "", "ip:port for the HTTP/JSON gateway")
+	gatewayOrigin := fs.String("gateway-origin", "", "Origin browsers may call the gateway from, or \"*\" for any")
+	seeds := fs.String("seeds", "", "Comma separated ip:port[@hex key] of miners to try when the server has no peers")
+	dataDir := fs.String("datadir", "", "Directory for ip-ports.txt and debug output")
+	keyDir := fs.String("keydir", "", "Key store directory")
+	keyName := fs.String("key", "", "Name of the key in the key store")
+	threads := fs.Int("threads", 0, "Number of proof of work threads")
+	opTimeout := fs.Int("optimeout", 0, "Seconds Draw and Delete wait for confirmations")
+	logLevel := fs.String("loglevel", "", "One of debug, info, error")
+
+	if err = fs.Parse(args); err != nil {
+		return config, err
+	}
+
+	if *path != "" {
+		buffer, err := ioutil.ReadFile(*path)
+		if err != nil {
+			return config, err
+		}
+
+		if err = json.Unmarshal(buffer, &config); err != nil {
+			return config, fmt.Errorf("parse config %s: %s", *path, err)
+		}
+	}
+
+	// Flags override the config file
+	setIfGiven(&config.ServerAddr, *server)
+	setIfGiven(&config.ListenAddr, *listen)
+	setIfGiven(&config.ArtNodeListenAddr, *artNodeListen)
+	setIfGiven(&config.GatewayListenAddr, *gateway)
+	setIfGiven(&config.GatewayOrigin, *gatewayOrigin)
+	setIfGiven(&config.DataDir, *dataDir)
+	setIfGiven(&config.KeyDir, *keyDir)
+	setIfGiven(&config.KeyName, *keyName)
+	setIfGiven(&config.LogLevel, *logLevel)
+	if *seeds != "" {
+		config.Seeds = strings.Split(*seeds, ",")
+	}
+	if *artNodePlaintext {
+		config.AllowArtNodePlaintext = true
+	}
+	if *threads > 0 {
+		config.Threads = *threads
+	}
+	if *opTimeout > 0 {
+		config.OpTimeoutSec = *opTimeout
+	}
+
+	// The key pair only comes as a pair, so a lone pubKey is an error
+	// rather than silently dropped
+	positional := fs.Args()
+	switch len(positional) {
+	case 0:
+	case 1:
+		config.ServerAddr = positional[0]
+	case 3:
+		config.ServerAddr = positional[0]
+		config.PubKey, config.PrivKey = positional[1], positional[2]
+	default:
+		return config, fmt.Errorf("usage: ink-miner [flags] [server-ip:port [pubKey privKey]], got %d arguments", len(positional))
+	}
+
+	return config, config.validate()
+}
+
+// Same as LoadConfig, but prints usage and exits on error
+func LoadConfigOrDie(args []string) Config {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "LoadConfig::", err)
+		os.Exit(1)
+	}
+	return config
+}
+
+func (c Config) validate() error {
+	if c.ServerAddr == "" {
+		return fmt.Errorf("no server address given")
+	}
+	if c.PrivKey == "" && c.KeyName == "" {
+		return fmt.Errorf("no key given: use -key or pass pubKey privKey")
+	}
+	if c.Threads < 1 {
+		return fmt.Errorf("threads must be >= 1, got %d", c.Threads)
+	}
+	if c.OpTimeoutSec < 1 {
+		return fmt.Errorf("op-timeout must be >= 1, got %d", c.OpTimeoutSec)
+	}
+	if _, ok := logLevels[strings.ToLower(c.LogLevel)]; !ok {
+		return fmt.Errorf("unknown log level %s", c.LogLevel)
+	}
+	return nil
+}
+
+func (c Config) OpTimeout() time.Duration {
+	return time.Duration(c.OpTimeoutSec) * time.Second
+}
+
+// Returns path inside the configured data directory
+func (c Config) DataPath(elem ...string) string {
+	return filepath.Join(append([]string{c.DataDir}, elem...)...)
+}
+
+// Applies the log level to the log package and validation tracing, which
+// don't go through Logf. Debug shows them; anything above that silences them.
+func (c Config) applyLogLevel() {
+	level := logLevels[strings.ToLower(c.LogLevel)]
+	LOG_VALIDATION = level <= LOG_DEBUG
+	if level > LOG_DEBUG {
+		log.SetOutput(ioutil.Discard)
+	}
+}
+
+// Prints only when the configured log level allows it
+func Logf(level int, format string, v ...interface{}) {
+	if LogEnabled(level) {
+		fmt.Printf(format+"\n", v...)
+	}
+}
+
+// Same as Logf, with the operands formatted like fmt.Println
+func Logln(level int, v ...interface{}) {
+	if LogEnabled(level) {
+		fmt.Println(v...)
+	}
+}
+
+// Whether messages at level are printed
+func LogEnabled(level int) bool {
+	return level >= logLevels[strings.ToLower(MinerConfig.LogLevel)]
+}
+
+func setIfGiven(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+/*******************************
+| Main
+********************************/
+
+// Starts the miner as described by config. passphrase unlocks the key store
+// key, it is ignored when config has a hex key pair.
+func MineWithConfig(config Config, passphrase string) {
+	MinerConfig = config
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "MineWithConfig:: could not create data dir:", err)
+		os.Exit(1)
+	}
+
+	// 1. Setup the singleton miner instance
+	MinerInstance = new(Miner)
+	if config.PrivKey != "" {
+		ExtractKeyPairs(config.PubKey, config.PrivKey)
+	} else if err := LoadKeyPair(config.KeyDir, config.KeyName, passphrase); err != nil {
+		fmt.Fprintln(os.Stderr, "MineWithConfig:: could not load key:", err)
+		os.Exit(1)
+	}
+
+	config.applyLogLevel()
+	startMiner(config.ServerAddr)
+}
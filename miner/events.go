@@ -0,0 +1,238 @@
+/*
+
+This file contains the canvas event feed for art nodes.
+
+GetCanvasEvents is a long-poll: the caller passes the last block it has seen
+and gets back everything that happened on the longest chain since then (new
+blocks, shapes added and deleted, reorgs). If nothing happened yet, the call
+waits for the next block. Passing the returned TipHash into the next call
+gives a live stream without re-walking the chain from the genesis block.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"../blockchain"
+	"../libminer"
+)
+
+const (
+	// Upper bound for CanvasEventsRequest.MaxWait, in seconds
+	MAX_EVENT_WAIT = 60
+)
+
+// Closed and replaced every time a block is inserted, so waiters can select
+// on a new block together with a timeout (which BlockCond can't do)
+var newBlockNotifier = struct {
+	sync.Mutex
+	ch chan struct{}
+}{ch: make(chan struct{})}
+
+func notifyNewBlock() {
+	newBlockNotifier.Lock()
+	close(newBlockNotifier.ch)
+	newBlockNotifier.ch = make(chan struct{})
+	newBlockNotifier.Unlock()
+}
+
+func newBlockChan() <-chan struct{} {
+	newBlockNotifier.Lock()
+	defer newBlockNotifier.Unlock()
+	return newBlockNotifier.ch
+}
+
+// Returns the events between FromBlockHash and the tip of the longest chain,
+// waiting up to MaxWait seconds for a new block if there are none yet.
+func (lmi *LibMinerInterface) GetCanvasEvents(req *libminer.Request, response *libminer.CanvasEventsResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var eventsReq libminer.CanvasEventsRequest
+		json.Unmarshal(req.Msg, &eventsReq)
+
+		wait := eventsReq.MaxWait
+		if wait > MAX_EVENT_WAIT {
+			wait = MAX_EVENT_WAIT
+		}
+		deadline := time.After(time.Duration(wait) * time.Second)
+
+		for {
+			// Grab the channel first so a block inserted while we compute
+			// the events still wakes us up
+			newBlock := newBlockChan()
+
+			events, tipHash, err := CanvasEvents(eventsReq.FromBlockHash)
+			if err != nil {
+				return errors.New(CheckStatusCode(err))
+			}
+
+			if len(events) > 0 || wait == 0 {
+				response.Events = events
+				response.TipHash = tipHash
+				return nil
+			}
+
+			select {
+			case <-newBlock:
+			case <-deadline:
+				response.Events = events
+				response.TipHash = tipHash
+				return nil
+			}
+		}
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Computes the events that take a viewer from fromHash to the tip of the
+// longest chain. Possible Errors:
+// - InvalidBlockHashError if fromHash isn't connected to the genesis block
+func CanvasEvents(fromHash string) (events []libminer.CanvasEvent, tipHash string, err error) {
+	genesisHash := MinerInstance.Settings.GenesisBlockHash
+	if fromHash == "" {
+		fromHash = genesisHash
+	}
+
+	fromPath, err := ConnectedPath(fromHash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	longest, _ := GetLongestPath(genesisHash)
+	fromHashes := ChainHashes(fromPath)
+	longestHashes := ChainHashes(longest)
+
+	ancestor := commonAncestor(fromHashes, longestHashes)
+
+	events = make([]libminer.CanvasEvent, 0)
+
+	// The caller is on a fork: undo its blocks back to the common ancestor
+	if ancestor < len(fromHashes)-1 {
+		events = append(events, libminer.CanvasEvent{
+			Type:       libminer.EVENT_REORG,
+			BlockHash:  longestHashes[ancestor],
+			BlockIndex: ancestor})
+
+		for i := len(fromPath) - 1; i > ancestor; i-- {
+			ops := fromPath[i].OpHistory
+			for j := len(ops) - 1; j >= 0; j-- {
+				events = append(events, shapeEvents(ops[j], fromPath, fromHashes[i], i, true)...)
+			}
+		}
+	}
+
+	for i := ancestor + 1; i < len(longest); i++ {
+		events = append(events, libminer.CanvasEvent{
+			Type:       libminer.EVENT_NEW_BLOCK,
+			BlockHash:  longestHashes[i],
+			BlockIndex: i,
+			PubKey:     longest[i].MinerPubKey})
+
+		for _, opInfo := range longest[i].OpHistory {
+			events = append(events, shapeEvents(opInfo, longest, longestHashes[i], i, false)...)
+		}
+	}
+
+	return events, longestHashes[len(longestHashes)-1], nil
+}
+
+// Returns the index of the last block both chains share, given their hashes
+// (see ChainHashes). Both start at the genesis block, so this is at least 0.
+func commonAncestor(aHashes, bHashes []string) int {
+	ancestor := 0
+	for ancestor+1 < len(aHashes) && ancestor+1 < len(bHashes) &&
+		aHashes[ancestor+1] == bHashes[ancestor+1] {
+		ancestor++
+	}
+	return ancestor
+}
+
+// Returns the path from the genesis block to blockHash. An orphan whose path
+// doesn't reach the genesis block counts as unknown. Possible Errors:
+// - InvalidBlockHashError
+func ConnectedPath(blockHash string) ([]blockchain.Block, error) {
+	info, ok := ReadPathMap(blockHash)
+	if !ok || len(info.Path) == 0 || info.Path[0].PrevHash != "" {
+		return nil, libminer.InvalidBlockHashError(blockHash)
+	}
+	return info.Path, nil
+}
+
+// Builds the shape events for opInfo. An UPDATE is the removal of the
+// replaced shape (looked up in chain) followed by the new shape, or the other
+// way around when it is undone.
+func shapeEvents(opInfo blockchain.OperationInfo, chain []blockchain.Block, blockHash string, index int, undo bool) []libminer.CanvasEvent {
+	if opInfo.Op.OpType != blockchain.UPDATE {
+		return []libminer.CanvasEvent{shapeEvent(opInfo, blockHash, index, undo)}
+	}
+
+	replaced, _ := blockchain.FindOperation(chain, opInfo.AddSig)
+	replaced.Op.OpType = blockchain.DELETE
+	oldShape := blockchain.OperationInfo{AddSig: opInfo.AddSig, PubKey: opInfo.PubKey, Op: replaced.Op}
+
+	newShape := opInfo
+	newShape.AddSig = ""
+	newShape.Op.OpType = blockchain.ADD
+
+	if undo {
+		return []libminer.CanvasEvent{
+			shapeEvent(newShape, blockHash, index, true),
+			shapeEvent(oldShape, blockHash, index, true)}
+	}
+	return []libminer.CanvasEvent{
+		shapeEvent(oldShape, blockHash, index, false),
+		shapeEvent(newShape, blockHash, index, false)}
+}
+
+// Builds the shape event for opInfo. undo flips ADD and DELETE for blocks
+// that were orphaned by a reorg.
+func shapeEvent(opInfo blockchain.OperationInfo, blockHash string, index int, undo bool) libminer.CanvasEvent {
+	added := (opInfo.Op.OpType != blockchain.DELETE) != undo
+
+	event := libminer.CanvasEvent{
+		BlockHash:  blockHash,
+		BlockIndex: index,
+		ShapeHash:  opInfo.OpSig,
+		PubKey:     opInfo.PubKey,
+		Op:         opInfo.Op}
+
+	// A DELETE refers to its shape by the ADD's signature
+	if opInfo.Op.OpType == blockchain.DELETE {
+		event.ShapeHash = opInfo.AddSig
+	}
+
+	if added {
+		event.Type = libminer.EVENT_SHAPE_ADDED
+		if event.Op.OpType == blockchain.DELETE {
+			// Undoing a DELETE brings back the shape it deleted
+			event.Op = blockchain.DeletedOp(event.Op)
+		} else if event.Op.OpType != blockchain.BATCH {
+			event.Op.OpType = blockchain.ADD
+		}
+	} else {
+		event.Type = libminer.EVENT_SHAPE_DELETED
+		event.Op.OpType = blockchain.DELETE
+	}
+	return event
+}
+
+// Returns the hash of every block in chain. The first block of a path is the
+// placeholder for the genesis block, so it gets the genesis hash.
+func ChainHashes(chain []blockchain.Block) []string {
+	hashes := make([]string, len(chain))
+	for i, block := range chain {
+		if i == 0 && block.PrevHash == "" {
+			hashes[i] = MinerInstance.Settings.GenesisBlockHash
+		} else {
+			hashes[i] = GetBlockHash(block)
+		}
+	}
+	return hashes
+}
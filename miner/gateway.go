@@ -0,0 +1,512 @@
+/*
+
+This file contains the HTTP/JSON gateway to the lib-miner API, so browsers
+(and anything else that isn't Go) can draw without going through net/rpc.
+
+Every call is a POST to /api/<Method> with a JSON body:
+
+  {
+    "msg": "<JSON encoded request, as a string>",
+
+    // Either the same signature blockartlib sends over RPC:
+    "hashed-msg": "<hex md5 of msg>",
+    "r": "<decimal>",
+    "s": "<decimal>",
+
+    // or a WebCrypto style signature (ECDSA with SHA-256 over msg,
+    // r and s concatenated, hex encoded):
+    "signature": "<hex>"
+  }
+
+The signature must be made with the miner's key, same as for the RPC API.
+So that a captured request can't be sent again, every msg also carries a
+"Nonce" (any string, unique per request) and a "Timestamp" (Unix seconds).
+Requests more than GATEWAY_MAX_SKEW away from the miner's clock, or whose
+nonce was already used, are refused, as are bodies over GATEWAY_MAX_BODY.
+Browsers may only call the gateway from MinerConfig.GatewayOrigin. Once
+verified, the gateway forwards msg to the matching LibMinerInterface call and
+replies with {"result": ...} or {"error": "<code> <message>"}.
+
+Methods and their msg, besides Nonce and Timestamp:
+
+  OpenCanvas      {}
+  GetInk          {}
+  GetGenesisBlock {}
+  AddShape        {"SVGString": "...", "Fill": "...", "Stroke": "...", "ValidateNum": 2}
+  SubmitShape     same as AddShape, returns right away
+  DeleteShape     {"ShapeHash": "...", "ValidateNum": 2}
+  SubmitDelete    same as DeleteShape, returns right away
+  GetOpStatus     {"ShapeHash": "..."}
+  GetShapes       {"BlockHash": "..."}
+  GetChildren     {"BlockHash": "..."}
+  GetSvgString    {"ShapeHash": "..."}
+  GetCanvasEvents {"FromBlockHash": "...", "MaxWait": 30}
+
+*/
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"../libminer"
+	"../utils"
+)
+
+const (
+	// Most a request's Timestamp may differ from the miner's clock
+	GATEWAY_MAX_SKEW = 5 * time.Minute
+	// Deadline for reading a request
+	GATEWAY_READ_TIMEOUT = 30 * time.Second
+	// Added to the longest a call may take (Draw and Delete wait up to the
+	// op timeout) for the write deadline
+	GATEWAY_WRITE_SLACK = 30 * time.Second
+	// Largest request body taken. The biggest msg is an AddShape, with an
+	// SVG string, fill and stroke of up to utils.MAX_SVG_LEN each. A byte
+	// takes at most 7 once escaped in msg and again in the body; 4 KB more
+	// covers the other fields and the signature.
+	GATEWAY_MAX_BODY = 3*7*utils.MAX_SVG_LEN + 4096
+)
+
+/*******************
+* TYPE_DEFINITIONS *
+*******************/
+
+type GatewayRequest struct {
+	Msg       string `json:"msg"`
+	HashedMsg string `json:"hashed-msg"`
+	R         string `json:"r"`
+	S         string `json:"s"`
+	Signature string `json:"signature"`
+}
+
+type GatewayResponse struct {
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+type GatewayCanvasResponse struct {
+	CanvasXMax uint32 `json:"canvas-x-max"`
+	CanvasYMax uint32 `json:"canvas-y-max"`
+}
+
+type GatewayInkResponse struct {
+	InkRemaining uint32 `json:"ink-remaining"`
+}
+
+type GatewayAddResponse struct {
+	ShapeHash    string `json:"shape-hash"`
+	BlockHash    string `json:"block-hash"`
+	InkRemaining uint32 `json:"ink-remaining"`
+}
+
+type GatewaySubmitResponse struct {
+	ShapeHash string `json:"shape-hash"`
+}
+
+type GatewayOpStatusResponse struct {
+	State         string `json:"state"`
+	BlockHash     string `json:"block-hash,omitempty"`
+	Confirmations int    `json:"confirmations"`
+	Reason        string `json:"reason,omitempty"`
+	InkRemaining  uint32 `json:"ink-remaining"`
+}
+
+type GatewayBlockResponse struct {
+	BlockHash string `json:"block-hash"`
+}
+
+type GatewayHashesResponse struct {
+	Hashes []string `json:"hashes"`
+}
+
+type GatewaySvgResponse struct {
+	SVGString string `json:"svg-string"`
+}
+
+// The replay protection fields every msg carries
+type gatewayFreshness struct {
+	Nonce     string
+	Timestamp int64
+}
+
+// Nonces used within GATEWAY_MAX_SKEW
+type nonceSet struct {
+	sync.Mutex
+	// Key: nonce. Val: until when it has to be remembered.
+	seen map[string]time.Time
+}
+
+var gatewayNonces = nonceSet{seen: make(map[string]time.Time)}
+
+// A gateway method: takes the verified msg and returns the JSON result
+type gatewayMethod func(lmi *LibMinerInterface, msg []byte) (interface{}, error)
+
+var gatewayMethods = map[string]gatewayMethod{
+	"OpenCanvas":      gatewayOpenCanvas,
+	"GetInk":          gatewayGetInk,
+	"GetGenesisBlock": gatewayGetGenesisBlock,
+	"AddShape":        gatewayAddShape,
+	"SubmitShape":     gatewaySubmitShape,
+	"DeleteShape":     gatewayDeleteShape,
+	"SubmitDelete":    gatewaySubmitDelete,
+	"GetOpStatus":     gatewayGetOpStatus,
+	"GetShapes":       gatewayGetShapes,
+	"GetChildren":     gatewayGetChildren,
+	"GetSvgString":    gatewayGetSvgString,
+	"GetCanvasEvents": gatewayGetCanvasEvents,
+}
+
+/***********************
+* FUNCTION_DEFINITIONS *
+***********************/
+
+// Serves the gateway on addr. Blocks, so run it in its own goroutine.
+func ServeGateway(addr string, lmi *LibMinerInterface) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		handleGateway(lmi, w, r)
+	})
+
+	longestCall := MinerConfig.OpTimeout()
+	if longestCall < MAX_EVENT_WAIT*time.Second {
+		longestCall = MAX_EVENT_WAIT * time.Second
+	}
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  GATEWAY_READ_TIMEOUT,
+		WriteTimeout: longestCall + GATEWAY_WRITE_SLACK}
+
+	Logf(LOG_INFO, "ServeGateway:: Listening on: %s", addr)
+	err := server.ListenAndServe()
+	CheckError(err, "ServeGateway:ListenAndServe")
+}
+
+func handleGateway(lmi *LibMinerInterface, w http.ResponseWriter, r *http.Request) {
+	if origin := MinerConfig.GatewayOrigin; origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	}
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method != "POST" {
+		writeGateway(w, http.StatusMethodNotAllowed, GatewayResponse{Error: "use POST"})
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/")
+	method, ok := gatewayMethods[name]
+	if !ok {
+		writeGateway(w, http.StatusNotFound, GatewayResponse{Error: "unknown method " + name})
+		return
+	}
+
+	var gwReq GatewayRequest
+	body := http.MaxBytesReader(w, r.Body, GATEWAY_MAX_BODY)
+	if err := json.NewDecoder(body).Decode(&gwReq); err != nil {
+		status := http.StatusBadRequest
+		if _, tooLarge := err.(*http.MaxBytesError); tooLarge {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeGateway(w, status, GatewayResponse{Error: err.Error()})
+		return
+	}
+
+	if !gwReq.verify(&MinerInstance.PrivKey.PublicKey) {
+		writeGateway(w, http.StatusUnauthorized, GatewayResponse{Error: "invalid user"})
+		return
+	}
+	if err := gwReq.checkFresh(); err != nil {
+		writeGateway(w, http.StatusUnauthorized, GatewayResponse{Error: err.Error()})
+		return
+	}
+
+	result, err := method(lmi, []byte(gwReq.Msg))
+	if err != nil {
+		writeGateway(w, http.StatusBadRequest, GatewayResponse{Error: err.Error()})
+		return
+	}
+	writeGateway(w, http.StatusOK, GatewayResponse{Result: result})
+}
+
+func writeGateway(w http.ResponseWriter, status int, resp GatewayResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Checks the signature on the request with either of the supported schemes
+func (gwReq GatewayRequest) verify(pubKey *ecdsa.PublicKey) bool {
+	msg := []byte(gwReq.Msg)
+
+	if gwReq.Signature != "" {
+		sig, err := hex.DecodeString(gwReq.Signature)
+		if err != nil || len(sig) == 0 || len(sig)%2 != 0 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		hash := sha256.Sum256(msg)
+		return ecdsa.Verify(pubKey, hash[:], r, s)
+	}
+
+	hashedMsg, err := hex.DecodeString(gwReq.HashedMsg)
+	if err != nil {
+		return false
+	}
+	r, okR := new(big.Int).SetString(gwReq.R, 10)
+	s, okS := new(big.Int).SetString(gwReq.S, 10)
+	if !okR || !okS {
+		return false
+	}
+
+	h := md5.Sum(msg)
+	return hex.EncodeToString(h[:]) == hex.EncodeToString(hashedMsg) && ecdsa.Verify(pubKey, hashedMsg, r, s)
+}
+
+// Checks the signed msg is recent and its nonce wasn't used before, then
+// records the nonce. Must be called after verify.
+func (gwReq GatewayRequest) checkFresh() error {
+	var fresh gatewayFreshness
+	if err := json.Unmarshal([]byte(gwReq.Msg), &fresh); err != nil {
+		return err
+	}
+	if fresh.Nonce == "" {
+		return fmt.Errorf("msg has no Nonce")
+	}
+
+	now := time.Now()
+	sent := time.Unix(fresh.Timestamp, 0)
+	if sent.Before(now.Add(-GATEWAY_MAX_SKEW)) || sent.After(now.Add(GATEWAY_MAX_SKEW)) {
+		return fmt.Errorf("msg Timestamp %d is not within %s of the miner's clock", fresh.Timestamp, GATEWAY_MAX_SKEW)
+	}
+
+	return gatewayNonces.use(fresh.Nonce, sent.Add(GATEWAY_MAX_SKEW), now)
+}
+
+// Records nonce until expiry. Fails if it is already recorded.
+func (n *nonceSet) use(nonce string, expiry, now time.Time) error {
+	n.Lock()
+	defer n.Unlock()
+
+	for used, until := range n.seen {
+		if now.After(until) {
+			delete(n.seen, used)
+		}
+	}
+
+	if _, ok := n.seen[nonce]; ok {
+		return fmt.Errorf("msg Nonce %s was already used", nonce)
+	}
+	n.seen[nonce] = expiry
+	return nil
+}
+
+// The caller was already verified by the gateway, so forward msg to the RPC
+// handlers signed with the miner's own key
+func internalRequest(msg []byte) (*libminer.Request, error) {
+	if len(msg) == 0 {
+		msg = []byte("{}")
+	}
+
+	h := md5.Sum(msg)
+	r, s, err := ecdsa.Sign(rand.Reader, MinerInstance.PrivKey, h[:])
+	if err != nil {
+		return nil, err
+	}
+	return &libminer.Request{Msg: msg, HashedMsg: h[:], R: *r, S: *s}, nil
+}
+
+/******************
+* GATEWAY_METHODS *
+******************/
+
+func gatewayOpenCanvas(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.RegisterResponse
+	if err = lmi.OpenCanvas(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayCanvasResponse{resp.CanvasXMax, resp.CanvasYMax}, nil
+}
+
+func gatewayGetInk(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.InkResponse
+	if err = lmi.GetInk(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayInkResponse{resp.InkRemaining}, nil
+}
+
+func gatewayGetGenesisBlock(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp string
+	if err = lmi.GetGenesisBlock(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayBlockResponse{resp}, nil
+}
+
+func gatewayAddShape(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.DrawResponse
+	if err = lmi.Draw(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayAddResponse{resp.ShapeHash, resp.BlockHash, resp.InkRemaining}, nil
+}
+
+func gatewaySubmitShape(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.SubmitResponse
+	if err = lmi.SubmitShape(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewaySubmitResponse{resp.ShapeHash}, nil
+}
+
+func gatewayDeleteShape(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.InkResponse
+	if err = lmi.Delete(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayInkResponse{resp.InkRemaining}, nil
+}
+
+func gatewaySubmitDelete(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.SubmitResponse
+	if err = lmi.SubmitDelete(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewaySubmitResponse{resp.ShapeHash}, nil
+}
+
+func gatewayGetOpStatus(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.OpStatusResponse
+	if err = lmi.GetOpStatus(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewayOpStatusResponse{
+		State:         resp.State.String(),
+		BlockHash:     resp.BlockHash,
+		Confirmations: resp.Confirmations,
+		Reason:        resp.Reason,
+		InkRemaining:  resp.InkRemaining}, nil
+}
+
+// Returns the shape hashes of the ops in a block
+func gatewayGetShapes(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.BlocksResponse
+	if err = lmi.GetBlock(req, &resp); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0)
+	for _, block := range resp.Blocks {
+		for _, opInfo := range block.OpHistory {
+			hashes = append(hashes, opInfo.OpSig)
+		}
+	}
+	return GatewayHashesResponse{hashes}, nil
+}
+
+// Returns the hashes of the children of a block
+func gatewayGetChildren(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.BlocksResponse
+	if err = lmi.GetChildren(req, &resp); err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0)
+	for _, block := range resp.Blocks {
+		hashes = append(hashes, GetBlockHash(block))
+	}
+	return GatewayHashesResponse{hashes}, nil
+}
+
+func gatewayGetSvgString(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.OpResponse
+	if err = lmi.GetOp(req, &resp); err != nil {
+		return nil, err
+	}
+	return GatewaySvgResponse{utils.GetHTMLSVGString(resp.Op)}, nil
+}
+
+func gatewayGetCanvasEvents(lmi *LibMinerInterface, msg []byte) (interface{}, error) {
+	req, err := internalRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp libminer.CanvasEventsResponse
+	if err = lmi.GetCanvasEvents(req, &resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,463 @@
+/*
+
+This file contains the inventory based gossip between miners.
+
+Instead of pushing every op and block to every peer, a miner announces the
+hashes of what it has. The peer answers with the hashes it hasn't seen and
+hasn't already asked someone else for, and only those payloads are
+delivered. Every miner announces what it accepts to its own peers in turn,
+so ops and blocks reach the whole network however many hops away, and each
+miner receives each payload about once.
+
+All sets are bounded LRUs: the hashes this miner has seen, the hashes it has
+asked for and is waiting on, the hashes each peer is known to have, and the
+payloads kept around to deliver. A request is recorded against the peer it
+was made to, and a payload is only taken from that peer.
+
+Blocks are delivered in compact form to peers that support it, see
+compact.go.
+
+Peer RPC calls:
+  Announce(args AnnounceArgs, reply *[]InvItem)
+  Deliver(args DeliverArgs, reply *DeliverReply)
+
+*/
+
+package miner
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"../blockchain"
+)
+
+// Inventory types
+const (
+	INV_OP = iota
+	INV_BLOCK
+)
+
+const (
+	// Hashes remembered as seen
+	SEEN_SET_SIZE = 8192
+	// Hashes remembered per peer as known to it
+	PEER_KNOWN_SIZE = 2048
+	// Payloads kept to deliver to peers that ask for them
+	PAYLOAD_CACHE_SIZE = 1024
+	// How long a hash requested from one peer isn't requested from others
+	REQUEST_TIMEOUT = 10 * time.Second
+	// Most items in a single Announce or Deliver
+	MAX_INV_ITEMS = 1024
+)
+
+// Hash of an op (its OpSig) or block, announced to a peer
+type InvItem struct {
+	Type int
+	Hash string
+	// Set when an op is republished by the tracker, so it is announced
+	// again to peers known to have it. Local only: it is cleared before
+	// items are sent, and ignored in items received.
+	Resend bool
+}
+
+type AnnounceArgs struct {
+	// Listen address of the announcing miner, as in PeerList
+	From  string
+	Items []InvItem
+}
+
+type DeliverArgs struct {
+	From          string
+	Ops           []blockchain.OperationInfo
+	Blocks        []blockchain.Block
+	CompactBlocks []CompactBlock
+}
+
+// Least recently used set of hashes, each with a value. Safe for concurrent
+// use.
+type LRUCache struct {
+	sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+// Gossip state of this miner
+var (
+	seenInv = NewLRUCache(SEEN_SET_SIZE)
+	// Key: requestKey(peer, hash). Val: time it was asked of peer.
+	requestedInv = NewLRUCache(SEEN_SET_SIZE)
+	// Key: hash. Val: time it was last asked of any peer.
+	inFlightInv = NewLRUCache(SEEN_SET_SIZE)
+	payloads    = NewLRUCache(PAYLOAD_CACHE_SIZE)
+
+	// Key: peer address. Val: *LRUCache of the hashes it is known to have.
+	peerKnown      = make(map[string]*LRUCache)
+	peerKnownMutex sync.Mutex
+
+	// 1 while a sync for a block with an unknown parent is pending
+	orphanSyncing int32
+)
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{capacity: capacity, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Adds or refreshes key. Evicts the least recently used key if full.
+// Returns false if key was already there.
+func (c *LRUCache) Add(key string, value interface{}) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry).value = value
+		c.order.MoveToFront(e)
+		return false
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key, value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+	return true
+}
+
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+func (c *LRUCache) Contains(key string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	_, ok := c.entries[key]
+	return ok
+}
+
+// Returns every value, most recently used first
+func (c *LRUCache) Values() []interface{} {
+	c.Lock()
+	defer c.Unlock()
+
+	values := make([]interface{}, 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		values = append(values, e.Value.(*lruEntry).value)
+	}
+	return values
+}
+
+func (c *LRUCache) Remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// Returns the set of hashes addr is known to have
+func knownBy(addr string) *LRUCache {
+	peerKnownMutex.Lock()
+	defer peerKnownMutex.Unlock()
+
+	known, ok := peerKnown[addr]
+	if !ok {
+		known = NewLRUCache(PEER_KNOWN_SIZE)
+		peerKnown[addr] = known
+	}
+	return known
+}
+
+// Forgets what a dropped peer had
+func forgetPeer(addr string) {
+	peerKnownMutex.Lock()
+	delete(peerKnown, addr)
+	peerKnownMutex.Unlock()
+}
+
+// Key of a request for hash made to peer, in requestedInv
+func requestKey(peer, hash string) string {
+	return peer + " " + hash
+}
+
+// Records that hash was asked of peer
+func markRequested(peer, hash string) {
+	now := time.Now()
+	requestedInv.Add(requestKey(peer, hash), now)
+	inFlightInv.Add(hash, now)
+}
+
+// Reports whether hash was asked of peer, and forgets the request if it was
+func takeRequested(peer, hash string) bool {
+	key := requestKey(peer, hash)
+	if !requestedInv.Contains(key) {
+		return false
+	}
+	requestedInv.Remove(key)
+	inFlightInv.Remove(hash)
+	return true
+}
+
+// Returns the items the caller should deliver: the ones this miner hasn't
+// seen and isn't already waiting on from another peer
+func (p *PeerRpc) Announce(args AnnounceArgs, reply *[]InvItem) error {
+	if err := p.checkFrom(args.From); err != nil {
+		return err
+	}
+	if IsBanned(args.From) {
+		return BannedPeerError(args.From)
+	}
+	if len(args.Items) > MAX_INV_ITEMS {
+		Penalize(args.From, PENALTY_OVERSIZED, fmt.Sprintf("announced %d items", len(args.Items)))
+		return fmt.Errorf("Announce: %d items, at most %d allowed", len(args.Items), MAX_INV_ITEMS)
+	}
+
+	known := knownBy(args.From)
+	wanted := make([]InvItem, 0)
+
+	for _, item := range args.Items {
+		known.Add(item.Hash, nil)
+
+		// item.Resend is ignored, a peer can't make us take what we have
+		// seen
+		if seenInv.Contains(item.Hash) {
+			continue
+		}
+		if item.Type == INV_BLOCK {
+			if _, ok := ReadBlockChainMap(item.Hash); ok {
+				continue
+			}
+		}
+		if requested, ok := inFlightInv.Get(item.Hash); ok && time.Since(requested.(time.Time)) < REQUEST_TIMEOUT {
+			continue
+		}
+
+		markRequested(args.From, item.Hash)
+		wanted = append(wanted, item)
+	}
+
+	*reply = wanted
+	return nil
+}
+
+// Receives the payloads asked for in Announce. Blocks are taken in order,
+// so parents should come before their children. Payloads that weren't asked
+// of this peer are dropped, and cost it points like invalid ones do. Replies
+// with what is still needed to rebuild the compact blocks.
+func (p *PeerRpc) Deliver(args DeliverArgs, reply *DeliverReply) error {
+	if err := p.checkFrom(args.From); err != nil {
+		return err
+	}
+	if IsBanned(args.From) {
+		return BannedPeerError(args.From)
+	}
+	items := len(args.Ops) + len(args.Blocks) + len(args.CompactBlocks)
+	if items > MAX_INV_ITEMS {
+		Penalize(args.From, PENALTY_OVERSIZED, fmt.Sprintf("delivered %d items", items))
+		return fmt.Errorf("Deliver: %d items, at most %d allowed", items, MAX_INV_ITEMS)
+	}
+
+	known := knownBy(args.From)
+
+	for _, opInfo := range args.Ops {
+		known.Add(opInfo.OpSig, nil)
+		if !takeRequested(args.From, opInfo.OpSig) {
+			Penalize(args.From, PENALTY_SPAM, "unrequested op "+opInfo.OpSig)
+			continue
+		}
+		seenInv.Add(opInfo.OpSig, nil)
+
+		err := p.receiveOp(opInfo)
+		if _, dup := err.(DuplicateError); err != nil && !dup {
+			Logln(LOG_INFO, "Deliver:: dropping op", opInfo.OpSig, ":", err)
+		}
+		// Ink and overlaps depend on our chain, the peer may be on another
+		// fork where the op is valid. Only garbage costs points.
+		if _, invalid := err.(InvalidOpError); invalid {
+			Penalize(args.From, PENALTY_INVALID_OP, "invalid op "+opInfo.OpSig)
+		}
+	}
+
+	for _, block := range args.Blocks {
+		hash := GetBlockHash(block)
+		known.Add(hash, nil)
+		if !takeRequested(args.From, hash) {
+			Penalize(args.From, PENALTY_SPAM, "unrequested block "+hash)
+			continue
+		}
+		p.acceptBlock(args.From, block)
+	}
+
+	reply.Missing = make(map[string][]int)
+	for _, compact := range args.CompactBlocks {
+		known.Add(compact.Hash, nil)
+		// The request is only taken once the block is rebuilt, the rest of
+		// it may still be delivered in BlockOps or in full
+		if !requestedInv.Contains(requestKey(args.From, compact.Hash)) {
+			Penalize(args.From, PENALTY_SPAM, "unrequested block "+compact.Hash)
+			continue
+		}
+		if seenInv.Contains(compact.Hash) {
+			continue
+		}
+
+		p.receiveCompact(args.From, compact, reply)
+	}
+
+	return nil
+}
+
+// Takes a block delivered by from, unless it was seen before. Invalid blocks
+// cost from points. A block whose parent we don't have can't be judged yet:
+// it isn't marked seen, so it is taken when announced again, and the chain
+// leading to it is fetched from from.
+func (p *PeerRpc) acceptBlock(from string, block blockchain.Block) {
+	hash := GetBlockHash(block)
+	if seenInv.Contains(hash) {
+		return
+	}
+
+	if _, ok := ReadBlockChainMap(block.PrevHash); !ok {
+		Logf(LOG_INFO, "acceptBlock:: parent of %s unknown, syncing with %s", hash, from)
+		syncOrphan(from)
+		return
+	}
+
+	if !seenInv.Add(hash, nil) {
+		return
+	}
+
+	if !VerifyBlock(block) {
+		Penalize(from, PENALTY_INVALID_POW, "invalid proof of work "+hash)
+		return
+	}
+	if !p.receiveBlock(block) {
+		Penalize(from, PENALTY_INVALID_BLOCK, "invalid block "+hash)
+	}
+}
+
+// Fetches the headers and blocks from has beyond our chain, in the
+// background. At most one of these syncs is waiting or running at a time.
+func syncOrphan(from string) {
+	peer, ok := PeerList.Get(from)
+	if !ok || !atomic.CompareAndSwapInt32(&orphanSyncing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&orphanSyncing, 0)
+		SyncWithPeers(map[string]*Peer{from: peer})
+	}()
+}
+
+// Announces items to every peer that isn't known to have them, and delivers
+// the ones each peer asks for. Each peer is handled on its own goroutine, see
+// peerset.go.
+func announce(items []InvItem) {
+	from := MinerInstance.Addr.String()
+
+	for addr, peer := range PeerList.Snapshot() {
+		if IsBanned(addr) {
+			continue
+		}
+		err := peer.Send(func(peer *Peer) { announceTo(peer, from, items) })
+		CheckError(err, "announce:"+addr)
+	}
+}
+
+func announceTo(peer *Peer, from string, items []InvItem) {
+	known := knownBy(peer.Addr)
+
+	fresh := make([]InvItem, 0, len(items))
+	for _, item := range items {
+		if item.Resend || !known.Contains(item.Hash) {
+			item.Resend = false
+			fresh = append(fresh, item)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+
+	var wanted []InvItem
+	err := peer.Call("Peer.Announce", AnnounceArgs{From: from, Items: fresh}, &wanted, CALL_TIMEOUT)
+	if CheckError(err, "announce:"+peer.Addr) {
+		return
+	}
+	for _, item := range fresh {
+		known.Add(item.Hash, nil)
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	args := DeliverArgs{From: from}
+	compact := peer.Supports(FEATURE_COMPACT_BLOCKS)
+	// Blocks sent in compact form, by hash
+	blocks := make(map[string]blockchain.Block)
+
+	for _, item := range wanted {
+		payload, ok := payloads.Get(item.Hash)
+		if !ok {
+			continue
+		}
+
+		switch item.Type {
+		case INV_OP:
+			args.Ops = append(args.Ops, payload.(blockchain.OperationInfo))
+		case INV_BLOCK:
+			block := payload.(blockchain.Block)
+			if compact && len(block.OpHistory) > 0 {
+				blocks[item.Hash] = block
+				args.CompactBlocks = append(args.CompactBlocks, NewCompactBlock(item.Hash, block))
+			} else {
+				args.Blocks = append(args.Blocks, block)
+			}
+		}
+	}
+
+	var reply DeliverReply
+	err = peer.Call("Peer.Deliver", args, &reply, CALL_TIMEOUT)
+	if CheckError(err, "announce:Deliver:"+peer.Addr) {
+		return
+	}
+	if len(reply.Missing) > 0 || len(reply.Full) > 0 {
+		completeCompactBlocks(peer, from, blocks, reply)
+	}
+}
+
+// Announces an op this miner accepted or submitted
+func PeerPropagateOp(op PropagateOpArgs) {
+	seenInv.Add(op.OpInfo.OpSig, nil)
+	payloads.Add(op.OpInfo.OpSig, op.OpInfo)
+	opPool.Add(op.OpInfo.OpSig, op.OpInfo)
+	announce([]InvItem{{Type: INV_OP, Hash: op.OpInfo.OpSig, Resend: op.Resend}})
+}
+
+// Announces a block this miner accepted or mined
+func PeerPropagateBlock(block PropagateBlockArgs) {
+	hash := GetBlockHash(block.Block)
+	seenInv.Add(hash, nil)
+	payloads.Add(hash, block.Block)
+	announce([]InvItem{{Type: INV_BLOCK, Hash: hash}})
+}
@@ -0,0 +1,137 @@
+/*
+
+This file contains the handshake miners exchange when they connect.
+
+Peer.Connect carries a Handshake both ways: the protocol version, the genesis
+hash, a digest of the network settings that decide which blocks are valid,
+the sender's best tip and the features it supports. Either side refuses the
+other if the genesis hash or settings digest differ, or if its protocol
+version is outside [MIN_PROTOCOL_VERSION, PROTOCOL_VERSION]. Optional
+behaviour can be rolled out behind a feature and used only with peers that
+announce it (see Peer.Supports).
+
+*/
+
+package miner
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"../minerserver"
+)
+
+const (
+	// Version of the peer protocol this miner speaks. Version 1 was the
+	// original push protocol with no handshake, version 2 ran in plaintext.
+	PROTOCOL_VERSION = 3
+	// Oldest version this miner still talks to
+	MIN_PROTOCOL_VERSION = 3
+)
+
+// Features a peer may announce
+const (
+	FEATURE_HEADERS_SYNC = "headers-sync"
+	FEATURE_INV_GOSSIP   = "inv-gossip"
+	FEATURE_BATCH_OP     = "batch-op"
+	FEATURE_UPDATE_OP    = "update-op"
+	// Blocks can be delivered as CompactBlock, see compact.go
+	FEATURE_COMPACT_BLOCKS = "compact-blocks"
+)
+
+// Features this miner supports
+var SupportedFeatures = []string{
+	FEATURE_HEADERS_SYNC,
+	FEATURE_INV_GOSSIP,
+	FEATURE_BATCH_OP,
+	FEATURE_UPDATE_OP,
+	FEATURE_COMPACT_BLOCKS,
+}
+
+type Handshake struct {
+	// Listen address of the sender, for the other side to connect back
+	Addr           net.Addr
+	Version        int
+	GenesisHash    string
+	SettingsDigest string
+	// Tip of the sender's longest chain and its height
+	TipHash  string
+	Height   int
+	Features []string
+}
+
+// Contains the reason a peer was refused
+type IncompatiblePeerError string
+
+func (e IncompatiblePeerError) Error() string {
+	return fmt.Sprintf("Incompatible peer: %s", string(e))
+}
+
+// Builds the handshake describing this miner
+func localHandshake() Handshake {
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	hashes := ChainHashes(chain)
+
+	return Handshake{
+		Addr:           MinerInstance.Addr,
+		Version:        PROTOCOL_VERSION,
+		GenesisHash:    MinerInstance.Settings.GenesisBlockHash,
+		SettingsDigest: SettingsDigest(MinerInstance.Settings),
+		TipHash:        hashes[len(hashes)-1],
+		Height:         len(chain) - 1,
+		Features:       SupportedFeatures}
+}
+
+// Returns the digest of the settings every miner of a network has to agree
+// on. Local policy like the heartbeat interval or the number of connections
+// is left out.
+func SettingsDigest(settings minerserver.MinerNetSettings) string {
+	consensus := struct {
+		GenesisBlockHash       string
+		InkPerOpBlock          uint32
+		InkPerNoOpBlock        uint32
+		PoWDifficultyOpBlock   uint8
+		PoWDifficultyNoOpBlock uint8
+		CanvasXMax             uint32
+		CanvasYMax             uint32
+	}{
+		settings.GenesisBlockHash,
+		settings.InkPerOpBlock,
+		settings.InkPerNoOpBlock,
+		settings.PoWDifficultyOpBlock,
+		settings.PoWDifficultyNoOpBlock,
+		settings.CanvasSettings.CanvasXMax,
+		settings.CanvasSettings.CanvasYMax}
+
+	bytes, _ := json.Marshal(consensus)
+	h := md5.Sum(bytes)
+	return hex.EncodeToString(h[:])
+}
+
+// Checks that the peer that sent h can be talked to
+func checkHandshake(h Handshake) error {
+	if h.Version < MIN_PROTOCOL_VERSION || h.Version > PROTOCOL_VERSION {
+		return IncompatiblePeerError(fmt.Sprintf("protocol version %d, need %d to %d",
+			h.Version, MIN_PROTOCOL_VERSION, PROTOCOL_VERSION))
+	}
+	if h.GenesisHash != MinerInstance.Settings.GenesisBlockHash {
+		return IncompatiblePeerError(fmt.Sprintf("genesis block %s", h.GenesisHash))
+	}
+	if h.SettingsDigest != SettingsDigest(MinerInstance.Settings) {
+		return IncompatiblePeerError(fmt.Sprintf("settings digest %s", h.SettingsDigest))
+	}
+	return nil
+}
+
+// Check if the peer announced feature in its handshake
+func (p *Peer) Supports(feature string) bool {
+	for _, f := range p.Handshake.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
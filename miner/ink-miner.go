@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 	"../blockchain"
+	"../keystore"
 	"../libminer"
 	"../minerserver"
 	"../pow"
@@ -39,14 +40,12 @@ var MinerInstance *Miner
 var ArtNodeList map[int]bool = make(map[int]bool)
 
 // List of peers WE connect TO, not peers that connect to US
-var PeerList map[string]*Peer = make(map[string]*Peer)
+var PeerList = NewPeerSet()
 
 var BlockCond *sync.Cond
 
 const (
-	// Global TTL of propagate requests
-	TTL = 2
-	// Maximum threads we will use for problem solving
+	// Default number of threads we will use for problem solving
 	MAX_THREADS = 1
 	// Num new blocks with no operation before repropagating op
 	BLOCKS_BEFORE_REPROPAGATE = 10
@@ -121,11 +120,24 @@ type LibMinerInterface struct {
 
 type MinerServerInterface struct {
 	Client *rpc.Client
+	// Address of the server, to reconnect to
+	addr  string
+	mutex sync.RWMutex
 }
 
 type Peer struct {
-	Client        *rpc.Client
-	LastHeartBeat time.Time
+	Addr   string
+	Client *rpc.Client
+	// What the peer sent when we connected
+	Handshake Handshake
+
+	// Guards lastHeartBeat, written from the peer's goroutine
+	mutex         sync.Mutex
+	lastHeartBeat time.Time
+	// Calls waiting for the peer's goroutine, see peerset.go
+	queue     chan func(*Peer)
+	quit      chan struct{}
+	closeOnce sync.Once
 }
 
 // For calculating the longest path
@@ -139,6 +151,7 @@ type LongestPathInfo struct {
 ********************************/
 func (m *Miner) ConnectToServer(ip string) {
 	miner_server_int := new(MinerServerInterface)
+	miner_server_int.addr = ip
 
 	LocalAddr, err := net.ResolveTCPAddr("tcp", ":0")
 	CheckError(err, "ConnectToServer:ResolveLocalAddr")
@@ -149,7 +162,7 @@ func (m *Miner) ConnectToServer(ip string) {
 	conn, err := net.DialTCP("tcp", LocalAddr, ServerAddr)
 	CheckError(err, "ConnectToServer:DialTCP")
 
-	fmt.Println("ConnectToServer::connecting to server on:", conn.LocalAddr().String())
+	Logln(LOG_INFO, "ConnectToServer::connecting to server on:", conn.LocalAddr().String())
 
 	client := rpc.NewClient(conn)
 	miner_server_int.Client = client
@@ -166,21 +179,26 @@ func OpenLibMinerConn(ip string, pop chan PropagateOpArgs, sop chan blockchain.O
 	server := rpc.NewServer()
 	server.Register(lib_miner_int)
 
+	// Art nodes connect over TLS with the miner's key, see transport.go
 	tcp, err := net.Listen("tcp", ip)
 	CheckError(err, "OpenLibMinerConn:Listen")
 
-	fmt.Println("Start writing ip:port to file")
-	f, err := os.Create("./ip-ports.txt")
+	Logln(LOG_DEBUG, "Start writing ip:port to file")
+	f, err := os.Create(MinerConfig.DataPath("ip-ports.txt"))
 	_ = CheckError(err, "OpenLibMinerConn:os.Create")
 	f.Write([]byte(tcp.Addr().String()))
 	f.Write([]byte("\n"))
 	f.Close()
-	fmt.Println("Finished writing to file")
+	Logln(LOG_DEBUG, "Finished writing to file")
 
 	MinerInstance.LMI = lib_miner_int
 
-	fmt.Println("OpenLibMinerConn:: Listening on: ", tcp.Addr().String())
-	server.Accept(tcp)
+	if MinerConfig.GatewayListenAddr != "" {
+		go ServeGateway(MinerConfig.GatewayListenAddr, lib_miner_int)
+	}
+
+	Logln(LOG_INFO, "OpenLibMinerConn:: Listening on: ", tcp.Addr().String())
+	serveArtNodes(tcp, server)
 }
 
 func (lmi *LibMinerInterface) OpenCanvas(req *libminer.Request, response *libminer.RegisterResponse) (err error) {
@@ -214,229 +232,209 @@ func (lmi *LibMinerInterface) GetInk(req *libminer.Request, response *libminer.I
 }
 
 
+// Submits an ADD operation and blocks until it has ValidateNum confirmations.
+// Built on SubmitShape and the op tracker, gives up after MinerConfig.OpTimeout.
 func (lmi *LibMinerInterface) Draw(req *libminer.Request, response *libminer.DrawResponse) (err error) {
 	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
-		MinerInstance.InkAmt = CalculateInk(utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey))
 		var drawReq libminer.DrawRequest
 		json.Unmarshal(req.Msg, &drawReq)
-		pubKeyString := utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)
 
-		// Create Operation
-		OpMutex.Lock()
-		op := blockchain.Operation{
-			OpType:    blockchain.ADD,
-			SVGString: drawReq.SVGString,
-			Fill:      drawReq.Fill,
-			Stroke:    drawReq.Stroke,
-			OpNum:     OpNum}
+		opInfo := newAddOp(drawReq)
+		Tracker.Submit(opInfo)
 
-		OpNum++
-		OpMutex.Unlock()
-
-		// Disseminate Operation
-		opBytes, _ := json.Marshal(op)
-		opSig, _ := MinerInstance.PrivKey.Sign(rand.Reader, opBytes, nil)
-		opSigStr := hex.EncodeToString(opSig)
-		opInfo := blockchain.OperationInfo{
-			AddSig: "",
-			OpSig:  opSigStr,
-			PubKey: pubKeyString,
-			Op:     op}
-
-		propOpArgs := PropagateOpArgs{
-			OpInfo: opInfo,
-			TTL:    TTL}
-
-		log.Printf("write to ch")
-		lmi.POpChan <- propOpArgs
-		log.Printf("write to ch")
-		lmi.SOpChan <- opInfo
-
-		blockHash := ""
-		count := 0
-
-		// keep trying to validate the operation
-		for {
-			BlockCond.L.Lock()
-			BlockCond.Wait()
-			BlockCond.L.Unlock()
-
-			// Check if it conflicts with the existing canvas
-			err := ValidateOperation(op, pubKeyString, opSigStr)
-			_, ok := err.(DuplicateError)
-			if !ok {
-				if err != nil {
-					return err
-				} else {
-					// Keep count of how many times no duplicate.
-					// If too many, reattempt operation
-					count++
-					if count > BLOCKS_BEFORE_REPROPAGATE {
-						log.Printf("write to ch")
-						lmi.POpChan <- propOpArgs
-						log.Printf("write to ch")
-						lmi.SOpChan <- opInfo
-						fmt.Println("No dupe count too high - republishing")
-						count = 0
-					}
-
-					fmt.Println("no duplicate yet - wait for new block")
-					continue
-				}
-			}
+		status, err := Tracker.Wait(opInfo.OpSig, drawReq.ValidateNum, MinerConfig.OpTimeout())
+		if err != nil {
+			return err
+		}
 
-			// Keep looping until there are NumValidate blocks
-			blockHash := GetBlockHashOfShapeHash(opInfo.OpSig)
-			if blockHash == "" {
-				fmt.Println("Weird, no block hash - sleep then continue...")
-				time.Sleep(1 * time.Second)
-				continue
-			}
+		response.InkRemaining = status.InkRemaining
+		response.ShapeHash = opInfo.OpSig
+		response.BlockHash = status.BlockHash
+		return nil
+	}
+	err = fmt.Errorf("invalid user")
+	return err
 
-			chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
-			numBlocksFollowing := 0
-			for i := len(chain) - 1; i >= 0; i-- {
-				blockByteData, _ := json.Marshal(chain[i])
-				hashedBlock := utils.ComputeHash(blockByteData)
-				hash := hex.EncodeToString(hashedBlock)
-				if hash == blockHash {
-					break
-				} else {
-					numBlocksFollowing++
-				}
-			}
+}
 
-			if numBlocksFollowing >= int(drawReq.ValidateNum) {
-				break
-			} else {
-				fmt.Println("Not enough blocks to validate yet:", numBlocksFollowing)
-			}
+// Submits a DELETE operation and blocks until it has ValidateNum confirmations.
+// Built on SubmitDelete and the op tracker, gives up after MinerConfig.OpTimeout.
+func (lmi *LibMinerInterface) Delete(req *libminer.Request, response *libminer.InkResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var deleteReq libminer.DeleteRequest
+		json.Unmarshal(req.Msg, &deleteReq)
+		Logln(LOG_DEBUG, "Delete called!")
+
+		opInfo, err := newDeleteOp(deleteReq)
+		if err != nil {
+			return err
 		}
+		Tracker.Submit(opInfo)
 
-		response.InkRemaining = uint32(CalculateInk(pubKeyString))
-		response.ShapeHash = opInfo.OpSig
-		response.BlockHash = blockHash
+		Logln(LOG_DEBUG, "Delete ok - waiting now")
+		status, err := Tracker.Wait(opInfo.OpSig, deleteReq.ValidateNum, MinerConfig.OpTimeout())
+		if err != nil {
+			return err
+		}
+
+		response.InkRemaining = status.InkRemaining
 		return nil
 	}
+
 	err = fmt.Errorf("invalid user")
 	return err
+}
+
+// Submits an ADD operation and returns its ShapeHash right away.
+// Use GetOpStatus to follow it.
+func (lmi *LibMinerInterface) SubmitShape(req *libminer.Request, response *libminer.SubmitResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var drawReq libminer.DrawRequest
+		json.Unmarshal(req.Msg, &drawReq)
+
+		opInfo := newAddOp(drawReq)
+		Tracker.Submit(opInfo)
 
+		response.ShapeHash = opInfo.OpSig
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
 }
 
-func (lmi *LibMinerInterface) Delete(req *libminer.Request, response *libminer.InkResponse) (err error) {
+// Submits a DELETE operation and returns the ShapeHash of the delete op right
+// away. Use GetOpStatus to follow it.
+func (lmi *LibMinerInterface) SubmitDelete(req *libminer.Request, response *libminer.SubmitResponse) (err error) {
 	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
 		var deleteReq libminer.DeleteRequest
 		json.Unmarshal(req.Msg, &deleteReq)
-		pubKeyString := utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)
-		fmt.Println("Delete called!")
 
-		// Check if deletion is allowed
-		path, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
-		err := MinerInstance.checkDeletion(deleteReq.ShapeHash, pubKeyString, path)
+		opInfo, err := newDeleteOp(deleteReq)
 		if err != nil {
 			return err
 		}
+		Tracker.Submit(opInfo)
 
-		// Find the ADD Operation for metadata
-		addBlockHash := GetBlockHashOfShapeHash(deleteReq.ShapeHash)
-		if addBlockHash == "" {
-			code := CheckStatusCode(libminer.ShapeOwnerError(deleteReq.ShapeHash))
-			return errors.New(code)
+		response.ShapeHash = opInfo.OpSig
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Submits a BATCH operation and blocks until it has ValidateNum
+// confirmations. The ShapeHash in the response deletes the whole batch.
+func (lmi *LibMinerInterface) DrawBatch(req *libminer.Request, response *libminer.DrawResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var batchReq libminer.BatchRequest
+		json.Unmarshal(req.Msg, &batchReq)
+
+		opInfo, err := newBatchOp(batchReq)
+		if err != nil {
+			return err
 		}
+		Tracker.Submit(opInfo)
 
-		addBlock := GetBlock(addBlockHash)
-		var addOpInfo blockchain.OperationInfo
-		for _, addInfo := range addBlock.OpHistory {
-			if addInfo.OpSig == deleteReq.ShapeHash {
-				addOpInfo = addInfo
-				break
-			}
+		status, err := Tracker.Wait(opInfo.OpSig, batchReq.ValidateNum, MinerConfig.OpTimeout())
+		if err != nil {
+			return err
 		}
 
-		if addOpInfo.Op.OpType != blockchain.ADD {
-			code := CheckStatusCode(libminer.ShapeOwnerError(deleteReq.ShapeHash))
-			return errors.New(code)
+		response.InkRemaining = status.InkRemaining
+		response.ShapeHash = opInfo.OpSig
+		response.BlockHash = status.BlockHash
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Submits a BATCH operation and returns its ShapeHash right away.
+// Use GetOpStatus to follow it.
+func (lmi *LibMinerInterface) SubmitBatch(req *libminer.Request, response *libminer.SubmitResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var batchReq libminer.BatchRequest
+		json.Unmarshal(req.Msg, &batchReq)
+
+		opInfo, err := newBatchOp(batchReq)
+		if err != nil {
+			return err
 		}
+		Tracker.Submit(opInfo)
 
-		OpMutex.Lock()
-		op := blockchain.Operation{
-			OpType:    blockchain.DELETE,
-			SVGString: addOpInfo.Op.SVGString,
-			Fill:      addOpInfo.Op.Fill,
-			Stroke:    addOpInfo.Op.Stroke,
-			OpNum:     OpNum}
+		response.ShapeHash = opInfo.OpSig
+		return nil
+	}
 
-		OpNum++
-		OpMutex.Unlock()
-
-		// Disseminate Operation
-		opBytes, _ := json.Marshal(op)
-		opSig, _ := MinerInstance.PrivKey.Sign(rand.Reader, opBytes, nil)
-		opInfo := blockchain.OperationInfo{
-			AddSig: deleteReq.ShapeHash,
-			OpSig:  hex.EncodeToString(opSig),
-			PubKey: pubKeyString,
-			Op:     op}
-
-		propOpArgs := PropagateOpArgs{
-			OpInfo: opInfo,
-			TTL:    TTL}
-
-		log.Printf("write to ch")
-		lmi.POpChan <- propOpArgs
-		log.Printf("write to ch")
-		lmi.SOpChan <- opInfo
-
-		count := 0
-
-		fmt.Println("Delete ok - waiting now")
-
-		// keep trying to validate the operation
-		for {
-			BlockCond.L.Lock()
-			BlockCond.Wait()
-			BlockCond.L.Unlock()
-
-			// Keep looping until there are NumValidate blocks
-			blockHash := GetBlockHashOfShapeHash(opInfo.OpSig)
-			if blockHash == "" {
-				fmt.Println("No del yet - sleep then continue...")
-				count++
+	err = fmt.Errorf("invalid user")
+	return err
+}
 
-				if count > BLOCKS_BEFORE_REPROPAGATE {
-					log.Printf("write to ch")
-					lmi.POpChan <- propOpArgs
-					log.Printf("write to ch")
-					lmi.SOpChan <- opInfo
-					count = 0
-				}
+// Replaces one of the caller's shapes with a new one in a single UPDATE
+// operation and waits for ValidateNum confirmations
+func (lmi *LibMinerInterface) Update(req *libminer.Request, response *libminer.DrawResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var updateReq libminer.UpdateRequest
+		json.Unmarshal(req.Msg, &updateReq)
 
-				fmt.Println("no duplicate yet - wait for new block")
-				continue
-			}
+		opInfo, err := newUpdateOp(updateReq)
+		if err != nil {
+			return err
+		}
+		Tracker.Submit(opInfo)
 
+		status, err := Tracker.Wait(opInfo.OpSig, updateReq.ValidateNum, MinerConfig.OpTimeout())
+		if err != nil {
+			return err
+		}
 
-			chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
-			numBlocksFollowing := 0
-			for i := len(chain) - 1; i >= 0; i-- {
-				blockByteData, _ := json.Marshal(chain[i])
-				hashedBlock := utils.ComputeHash(blockByteData)
-				hash := hex.EncodeToString(hashedBlock)
-				if hash == blockHash {
-					break
-				} else {
-					numBlocksFollowing++
-				}
-			}
+		response.InkRemaining = status.InkRemaining
+		response.ShapeHash = opInfo.OpSig
+		response.BlockHash = status.BlockHash
+		return nil
+	}
 
-			if numBlocksFollowing >= int(deleteReq.ValidateNum) {
-				break
-			} else {
-				fmt.Println("Not enough blocks to validate yet:", numBlocksFollowing)
-			}
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Submits an UPDATE operation and returns the ShapeHash of the new shape
+// right away. Use GetOpStatus to follow it.
+func (lmi *LibMinerInterface) SubmitUpdate(req *libminer.Request, response *libminer.SubmitResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var updateReq libminer.UpdateRequest
+		json.Unmarshal(req.Msg, &updateReq)
+
+		opInfo, err := newUpdateOp(updateReq)
+		if err != nil {
+			return err
+		}
+		Tracker.Submit(opInfo)
+
+		response.ShapeHash = opInfo.OpSig
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Returns the state of an operation submitted through this miner
+func (lmi *LibMinerInterface) GetOpStatus(req *libminer.Request, response *libminer.OpStatusResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var statusReq libminer.OpStatusRequest
+		json.Unmarshal(req.Msg, &statusReq)
+
+		status, ok := Tracker.Status(statusReq.ShapeHash)
+		if !ok {
+			code := CheckStatusCode(libminer.InvalidShapeHashError(statusReq.ShapeHash))
+			return errors.New(code)
 		}
 
-		response.InkRemaining = uint32(CalculateInk(pubKeyString))
+		*response = status
 		return nil
 	}
 
@@ -444,6 +442,137 @@ func (lmi *LibMinerInterface) Delete(req *libminer.Request, response *libminer.I
 	return err
 }
 
+// Creates and signs an ADD operation for drawReq
+func newAddOp(drawReq libminer.DrawRequest) blockchain.OperationInfo {
+	MinerInstance.InkAmt = CalculateInk(utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey))
+
+	OpMutex.Lock()
+	op := blockchain.Operation{
+		OpType:    blockchain.ADD,
+		SVGString: drawReq.SVGString,
+		Fill:      drawReq.Fill,
+		Stroke:    drawReq.Stroke,
+		OpNum:     OpNum}
+
+	OpNum++
+	OpMutex.Unlock()
+
+	return signOp(op, "")
+}
+
+// Creates and signs a BATCH operation for batchReq. Its shapes are checked
+// for bounds and syntax right away so a bad batch isn't propagated.
+func newBatchOp(batchReq libminer.BatchRequest) (opInfo blockchain.OperationInfo, err error) {
+	MinerInstance.InkAmt = CalculateInk(utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey))
+
+	OpMutex.Lock()
+	op, err := blockchain.NewBatchOperation(batchReq.Shapes, OpNum)
+	if err == nil {
+		OpNum++
+	}
+	OpMutex.Unlock()
+
+	if err != nil {
+		code := CheckStatusCode(libminer.InvalidShapeSvgStringError(err.Error()))
+		return opInfo, errors.New(code)
+	}
+
+	if _, err = MinerInstance.getShapesFromOp(op); err != nil {
+		return opInfo, errors.New(CheckStatusCode(err))
+	}
+
+	return signOp(op, ""), nil
+}
+
+// Creates and signs an UPDATE operation for updateReq, after checking that
+// this miner owns the replaced shape and that the new one parses
+func newUpdateOp(updateReq libminer.UpdateRequest) (opInfo blockchain.OperationInfo, err error) {
+	pubKeyString := utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)
+	MinerInstance.InkAmt = CalculateInk(pubKeyString)
+
+	path, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	err = MinerInstance.checkDeletion(updateReq.ShapeHash, pubKeyString, path)
+	if err != nil {
+		return opInfo, errors.New(CheckStatusCode(err))
+	}
+
+	OpMutex.Lock()
+	op := blockchain.Operation{
+		OpType:    blockchain.UPDATE,
+		SVGString: updateReq.SVGString,
+		Fill:      updateReq.Fill,
+		Stroke:    updateReq.Stroke,
+		OpNum:     OpNum}
+
+	OpNum++
+	OpMutex.Unlock()
+
+	if _, err = MinerInstance.getShapesFromOp(op); err != nil {
+		return opInfo, errors.New(CheckStatusCode(err))
+	}
+
+	return signOp(op, updateReq.ShapeHash), nil
+}
+
+// Creates and signs a DELETE operation for deleteReq, after checking that
+// this miner owns the shape and hasn't deleted it yet
+func newDeleteOp(deleteReq libminer.DeleteRequest) (opInfo blockchain.OperationInfo, err error) {
+	pubKeyString := utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)
+
+	// Check if deletion is allowed
+	path, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	err = MinerInstance.checkDeletion(deleteReq.ShapeHash, pubKeyString, path)
+	if err != nil {
+		return opInfo, err
+	}
+
+	// Find the ADD Operation for metadata
+	addBlockHash := GetBlockHashOfShapeHash(deleteReq.ShapeHash)
+	if addBlockHash == "" {
+		code := CheckStatusCode(libminer.ShapeOwnerError(deleteReq.ShapeHash))
+		return opInfo, errors.New(code)
+	}
+
+	addBlock := GetBlock(addBlockHash)
+	var addOpInfo blockchain.OperationInfo
+	for _, addInfo := range addBlock.OpHistory {
+		if addInfo.OpSig == deleteReq.ShapeHash {
+			addOpInfo = addInfo
+			break
+		}
+	}
+
+	if addOpInfo.OpSig == "" || addOpInfo.Op.OpType == blockchain.DELETE {
+		code := CheckStatusCode(libminer.ShapeOwnerError(deleteReq.ShapeHash))
+		return opInfo, errors.New(code)
+	}
+
+	OpMutex.Lock()
+	op := blockchain.Operation{
+		OpType:    blockchain.DELETE,
+		SVGString: addOpInfo.Op.SVGString,
+		Fill:      addOpInfo.Op.Fill,
+		Stroke:    addOpInfo.Op.Stroke,
+		OpNum:     OpNum}
+
+	OpNum++
+	OpMutex.Unlock()
+
+	return signOp(op, deleteReq.ShapeHash), nil
+}
+
+// Signs op with the miner's key. addSig is the ShapeHash being deleted or
+// replaced, or ""
+func signOp(op blockchain.Operation, addSig string) blockchain.OperationInfo {
+	opBytes, _ := json.Marshal(op)
+	opSig, _ := MinerInstance.PrivKey.Sign(rand.Reader, opBytes, nil)
+	return blockchain.OperationInfo{
+		AddSig: addSig,
+		OpSig:  hex.EncodeToString(opSig),
+		PubKey: utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey),
+		Op:     op}
+}
+
 func (lmi *LibMinerInterface) GetGenesisBlock(req *libminer.Request, response *string) (err error) {
 	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
 		*response = MinerInstance.Settings.GenesisBlockHash
@@ -535,7 +664,7 @@ func InsertBlock(newBlock blockchain.Block) (err error) {
 	newBlockHash := GetBlockHash(newBlock)
 	if _, ok := ReadBlockChainMap(newBlockHash); !ok && VerifyBlock(newBlock) {
 		// Create a new BlockNode for newBlock and append it to BlockNodeArray
-		fmt.Println("inserting:< Q", newBlock.PrevHash, ":", newBlock.Nonce)
+		Logln(LOG_DEBUG, "inserting:< Q", newBlock.PrevHash, ":", newBlock.Nonce)
 		newPathInfo := LongestPathInfo{Len: 1, Path: []blockchain.Block{newBlock}}
 
 		existingChildren, _ := ReadParentMap(newBlockHash)
@@ -594,6 +723,7 @@ func InsertBlock(newBlock blockchain.Block) (err error) {
 		BlockCond.L.Lock()
 		BlockCond.Broadcast()
 		BlockCond.L.Unlock()
+		notifyNewBlock()
 
 		//fmt.Println("parent's node with new child:", parentBlockNode)
 		return nil
@@ -619,8 +749,12 @@ func GetBlockChildren(blockHash string) []blockchain.Block {
 }
 
 func VerifyBlock(block blockchain.Block) bool {
-	hash := GetBlockHash(block)
-	if len(block.OpHistory) == 0 {
+	return verifyHash(GetBlockHash(block), len(block.OpHistory))
+}
+
+// Checks that hash meets the difficulty of a block with numOps ops
+func verifyHash(hash string, numOps int) bool {
+	if numOps == 0 {
 		return pow.Verify(hash, int(MinerInstance.Settings.PoWDifficultyNoOpBlock))
 	}
 	return pow.Verify(hash, int(MinerInstance.Settings.PoWDifficultyOpBlock))
@@ -732,7 +866,7 @@ func WriteBlockNodeArray(b blockchain.BlockNode) int {
 		return len(BlockNodeArray) - 1
 	}
 
-	fmt.Println("Already added: %s", GetBlockHash(b.Block))
+	Logf(LOG_DEBUG, "Already added: %s", GetBlockHash(b.Block))
 	return i
 }
 
@@ -795,6 +929,8 @@ func GetPath(targetBlockHash string) []blockchain.Block {
 func CalculateInk(minerKey string) int {
 	blockChain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
 	var inkAmt uint32
+	// Cost of the shapes of minerKey, for the refund of an UPDATE or DELETE
+	shapeCosts := make(map[string]int)
 	for _, block := range blockChain {
 		if block.MinerPubKey == minerKey {
 			if len(block.OpHistory) == 0 {
@@ -807,22 +943,31 @@ func CalculateInk(minerKey string) int {
 		for _, opInfo := range block.OpHistory {
 			op := opInfo.Op
 			if opInfo.PubKey == minerKey {
-				shape, err := MinerInstance.getShapeFromOp(op)
+				// A DELETE refunds what the deleted shape cost
+				if op.OpType == blockchain.DELETE {
+					inkAmt += uint32(shapeCosts[opInfo.AddSig])
+					continue
+				}
+
+				cost, err := MinerInstance.opCost(op)
 				if err != nil {
-					fmt.Println("CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
+					Logln(LOG_ERROR, "CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
 					continue
 				}
 
-				_, cost := shape.SubArrayAndCost()
-				if op.OpType == blockchain.ADD {
+				switch op.OpType {
+				case blockchain.UPDATE:
+					inkAmt += uint32(shapeCosts[opInfo.AddSig])
 					inkAmt -= uint32(cost)
-				} else {
-					inkAmt += uint32(cost)
+					shapeCosts[opInfo.OpSig] = cost
+				default:
+					inkAmt -= uint32(cost)
+					shapeCosts[opInfo.OpSig] = cost
 				}
 			}
 		}
 	}
-	fmt.Println("this miner has this much ink:", int(inkAmt))
+	Logln(LOG_DEBUG, "this miner has this much ink:", int(inkAmt))
 	return int(inkAmt)
 }
 
@@ -833,55 +978,121 @@ func CalculateInk(minerKey string) int {
 func (msi *MinerServerInterface) Register(minerAddr net.Addr) {
 	reqArgs := minerserver.MinerInfo{Address: minerAddr, Key: MinerInstance.PrivKey.PublicKey}
 	var resp minerserver.MinerNetSettings
-	err := msi.Client.Call("RServer.Register", reqArgs, &resp)
+	err := msi.call("RServer.Register", reqArgs, &resp)
+	if CheckError(err, "Register:Client.Call") && MinerInstance.Settings.GenesisBlockHash != "" {
+		// Keep the settings we have while the server is unreachable
+		return
+	}
 	resp.PoWDifficultyOpBlock ++
 	resp.PoWDifficultyNoOpBlock ++
-	CheckError(err, "Register:Client.Call")
 	MinerInstance.Settings = resp
 }
 
 func (msi *MinerServerInterface) ServerHeartBeat() {
 	var ignored bool
 	//fmt.Println("ServerHeartBeat::Sending heartbeat")
-	err := msi.Client.Call("RServer.HeartBeat", MinerInstance.PrivKey.PublicKey, &ignored)
+	err := msi.call("RServer.HeartBeat", MinerInstance.PrivKey.PublicKey, &ignored)
 	if CheckError(err, "ServerHeartBeat") {
-		//Reconnect to server if timed out
+		// The server answered but doesn't know us (it forgot us or restarted
+		// without its registry), otherwise the connection is gone
+		if _, ok := err.(rpc.ServerError); !ok && CheckError(msi.reconnect(), "ServerHeartBeat:reconnect") {
+			return
+		}
+		// Register again, with the same key and address
 		msi.Register(MinerInstance.Addr)
+		// The restarted server doesn't know our degree yet
+		reportedDegree = -1
+	}
+}
+
+// Calls method on the server, giving up after CALL_TIMEOUT
+func (msi *MinerServerInterface) call(method string, args interface{}, reply interface{}) error {
+	msi.mutex.RLock()
+	client := msi.Client
+	msi.mutex.RUnlock()
+	return callWithTimeout(client, method, args, reply, CALL_TIMEOUT)
+}
+
+// Dials the server again, for when it went away or restarted
+func (msi *MinerServerInterface) reconnect() error {
+	conn, err := net.DialTimeout("tcp", msi.addr, CALL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	Logf(LOG_INFO, "reconnect:: reconnected to server on %s", conn.LocalAddr())
+
+	msi.mutex.Lock()
+	old := msi.Client
+	msi.Client = rpc.NewClient(conn)
+	msi.mutex.Unlock()
+	old.Close()
+	return nil
+}
+
+// Degree last reported to the server, -1 if none
+var reportedDegree = -1
+
+// Reports the number of distinct peers we are connected to, both ways, to the
+// server if it changed. The server uses it to hand out the least connected
+// miners.
+func (msi *MinerServerInterface) ReportDegree() {
+	degree := peerDegree()
+	if degree == reportedDegree {
+		return
+	}
+
+	var ignored bool
+	report := minerserver.DegreeReport{Key: MinerInstance.PrivKey.PublicKey, Degree: degree}
+	err := msi.call("RServer.ReportDegree", report, &ignored)
+	if !CheckError(err, "ReportDegree") {
+		reportedDegree = degree
 	}
 }
 
+// Connects to the addresses we aren't connected to yet, each on its own
+// goroutine so a slow peer doesn't hold up the others
 func (msi *MinerServerInterface) GetPeers(addrSet []net.Addr) {
-	var blockchainResp []blockchain.Block
 	for _, addr := range addrSet {
-		if _, ok := PeerList[addr.String()]; !ok {
-			fmt.Println("GetPeers::Connecting to address: ", addr.String())
-			LocalAddr, err := net.ResolveTCPAddr("tcp", ":0")
-			if CheckError(err, "GetPeers:ResolvePeerAddr") {
-				continue
-			}
+		if IsBanned(addr.String()) {
+			continue
+		}
+		if !PeerList.Reserve(addr.String()) {
+			continue
+		}
 
-			PeerAddr, err := net.ResolveTCPAddr("tcp", addr.String())
-			if CheckError(err, "GetPeers:ResolveLocalAddr") {
-				continue
-			}
+		go connectPeer(addr.String())
+	}
+}
 
-			conn, err := net.DialTCP("tcp", LocalAddr, PeerAddr)
-			if CheckError(err, "GetPeers:DialTCP") {
-				continue
-			}
+// Dials addr, which must be reserved in PeerList, and adds it as a peer
+func connectPeer(addr string) {
+	Logln(LOG_INFO, "GetPeers::Connecting to address: ", addr)
+	// Fails unless the peer owns the key registered for addr
+	client, key, err := dialPeer(addr)
+	if CheckError(err, "GetPeers:dialPeer") {
+		PeerList.Release(addr)
+		return
+	}
+
+	var handshake Handshake
+	err = callWithTimeout(client, "Peer.Connect", localHandshake(), &handshake, CALL_TIMEOUT)
+	if err == nil {
+		err = checkHandshake(handshake)
+	}
+	if CheckError(err, "GetPeers:Connect") {
+		client.Close()
+		PeerList.Release(addr)
+		return
+	}
 
-			client := rpc.NewClient(conn)
+	peer := NewPeer(addr, client, handshake)
+	PeerList.Add(peer)
+	setPeerConnected(addr, true)
+	Book.Seen(addr, key)
 
-			args := ConnectArgs{MinerInstance.Addr}
-			err = client.Call("Peer.Connect", args, &blockchainResp)
-			if CheckError(err, "GetPeers:Connect") {
-				continue
-			}
-			for _, block := range blockchainResp {
-				InsertBlock(block)
-			}
-			PeerList[addr.String()] = &Peer{client, time.Now()}
-		}
+	// Only sync if the peer is ahead of us or on another branch
+	if _, ok := ReadBlockChainMap(handshake.TipHash); !ok {
+		SyncWithPeers(map[string]*Peer{addr: peer})
 	}
 }
 
@@ -892,8 +1103,8 @@ func (msi *MinerServerInterface) GetPeers(addrSet []net.Addr) {
 // 1. Send the server heartbeat to maintain connectivity
 // 2. Send miner heartbeats to maintain connectivity with peers
 // 3. Check for stale peers and remove them from the list
-// 4. Request new nodes from server and connect to them when peers drop too low
-// 5. When a operation or block is sent through the channel, heartbeat will be replaced by Propagate<Type>
+// 4. Look for new peers (server, address book, peer exchange) when peers drop too low, see addrbook.go
+// 5. When a operation or block is sent through the channel, heartbeat will be replaced by announcing it (see gossip.go)
 // This is the central point of control for the peer connectivity
 
 func ManageConnections(pop chan PropagateOpArgs, pblock chan PropagateBlockArgs, peerconn chan net.Addr) {
@@ -905,8 +1116,10 @@ func ManageConnections(pop chan PropagateOpArgs, pblock chan PropagateBlockArgs,
 		select {
 		case <-heartbeat:
 			MinerInstance.MSI.ServerHeartBeat()
+			MinerInstance.MSI.ReportDegree()
 			if count >= 50 {
 				PeerSync()
+				ExchangeAddresses()
 				count = 0
 			} else {
 				count++
@@ -924,74 +1137,52 @@ func ManageConnections(pop chan PropagateOpArgs, pblock chan PropagateBlockArgs,
 			PeerPropagateBlock(block)
 		default:
 			CheckLiveliness()
-			if len(PeerList) < int(MinerInstance.Settings.MinNumMinerConnections) {
-				var addrSet []net.Addr
-				MinerInstance.MSI.Client.Call("RServer.GetNodes", MinerInstance.PrivKey.PublicKey, &addrSet)
-				MinerInstance.MSI.GetPeers(addrSet)
-			}
+			DiscoverPeers()
 		}
 	}
 }
 
-// Try to sync up with peers once in a while
+// Try to sync up with peers once in a while, in the background. Only the
+// blocks we are missing are transferred, see SyncWithPeers.
 func PeerSync() {
-	fmt.Println("Performing a sync")
-	for addr, peer := range PeerList {
-		var blockchainResp []blockchain.Block
-		empty := new(Empty)
-		err := peer.Client.Call("Peer.GetBlockChain", empty, &blockchainResp)
-		if !CheckError(err, "PeerSync:"+addr) {
-			peer.LastHeartBeat = time.Now()
-			for _, block := range blockchainResp {
-				InsertBlock(block)
-			}
-		}
-	}
+	Logln(LOG_DEBUG, "Performing a sync")
+	go SyncWithPeers(PeerList.Snapshot())
 }
 
-// Send a heartbeat call to each peer
+// Queue a heartbeat call on each peer
 func PeerHeartBeats() {
-	for addr, peer := range PeerList {
+	PeerList.SendAll(func(peer *Peer) {
 		empty := new(Empty)
-		err := peer.Client.Call("Peer.Hb", &empty, &empty)
-		if !CheckError(err, "PeerHeartBeats:"+addr) {
-			peer.LastHeartBeat = time.Now()
+		err := peer.Call("Peer.Hb", &empty, &empty, CALL_TIMEOUT)
+		if !CheckError(err, "PeerHeartBeats:"+peer.Addr) {
+			peer.Touch()
 		}
-	}
-}
-
-// Send a PropagateOp call to each peer
-// Assumption: Nothing needs to be done on the miner itself, only send the op onwards
-func PeerPropagateOp(op PropagateOpArgs) {
-	for _, peer := range PeerList {
-		empty := new(Empty)
-		args := PropagateOpArgs{op.OpInfo, op.TTL}
-		peer.Client.Call("Peer.PropagateOp", args, &empty)
-	}
+	})
 }
 
-// Send a PropagateBlock call to each peer
-// Assumption: Nothing needs to be done on the miner itself, only send the block onwards
-func PeerPropagateBlock(block PropagateBlockArgs) {
-	for _, peer := range PeerList {
-		empty := new(Empty)
-		args := PropagateBlockArgs{block.Block, block.TTL}
-		peer.Client.Call("Peer.PropagateBlock", args, &empty)
-	}
-}
-
-// Look through current active connections and delete them if they are not live
+// Look through current active connections and delete them if they are not
+// live or have been banned
 func CheckLiveliness() {
 	interval := time.Duration(MinerInstance.Settings.HeartBeat) * time.Millisecond
-	for addr, peer := range PeerList {
-		if time.Since(peer.LastHeartBeat) > interval {
-			fmt.Println("Stale connection: ", addr, " deleting")
-			peer.Client.Close()
-			delete(PeerList, addr)
+	for addr, peer := range PeerList.Snapshot() {
+		stale := time.Since(peer.LastHeartBeat()) > interval
+		if stale || IsBanned(addr) {
+			Logln(LOG_INFO, "Stale or banned connection: ", addr, " deleting")
+			dropPeer(peer)
 		}
 	}
 }
 
+// Closes peer and removes it from PeerList, unless another connection to
+// its address has taken its place
+func dropPeer(peer *Peer) {
+	peer.Close()
+	if PeerList.RemovePeer(peer) {
+		forgetPeer(peer.Addr)
+		setPeerConnected(peer.Addr, false)
+	}
+}
+
 /*******************************
 | Crypto-Management
 ********************************/
@@ -1016,7 +1207,7 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 			// Assuming it is properly validated
 			// Add it to the block we were working on
 			// reissue job
-			fmt.Println("got new op to hash")
+			Logln(LOG_DEBUG, "got new op to hash")
 			// Kill current job
 			close(done)
 			close(solved)
@@ -1040,7 +1231,7 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 			// Assume that this block was validated
 			// Assume this is the next block to build off of
 			// Reissue a job with this blockhash as prevBlock
-			fmt.Println("got new block to hash")
+			Logln(LOG_DEBUG, "got new block to hash")
 
 			// Kill current job
 			close(done)
@@ -1060,7 +1251,7 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 			}
 		case sol := <-solved:
 			if len(sol.OpHistory) > 0 {
-				fmt.Println("got a solution", sol.OpHistory[0])
+				Logln(LOG_DEBUG, "got a solution", sol.OpHistory[0])
 			}
 
 			// Kill current job
@@ -1071,7 +1262,7 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 
 			// Insert block into our data structure
 			InsertBlock(sol)
-			pblock <- PropagateBlockArgs{sol, TTL}
+			pblock <- PropagateBlockArgs{sol}
 
 			//fmt.Println("inserted solution: ", BlockNodeArray)
 			// Start a job on the longest block in the chain
@@ -1083,7 +1274,7 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 			PrintBlockChain(chain)
 		default:
 			if CurrJobId == 0 {
-				fmt.Println("Initiating the first job")
+				Logln(LOG_DEBUG, "Initiating the first job")
 				done = NoopJob(MinerInstance.Settings.GenesisBlockHash, solved)
 			}
 		}
@@ -1093,14 +1284,14 @@ func ProblemSolver(sop chan blockchain.OperationInfo, sblock chan blockchain.Blo
 // Initiate a job with an empty op array and a blockhash
 func NoopJob(hash string, solved chan blockchain.Block) chan bool {
 	CurrJobId++
-	fmt.Println("Starting job:", CurrJobId)
+	Logln(LOG_DEBUG, "Starting job:", CurrJobId)
 	block := blockchain.Block{PrevHash: hash,
 		MinerPubKey: utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)}
 	done := make(chan bool)
-	for i := 0; i <= MAX_THREADS; i++ {
+	for i := 0; i < MinerConfig.Threads; i++ {
 		CurrJobId++
-		// Split up the start by the maximum number of threads we allow
-		start := math.MaxUint32 / MAX_THREADS * i
+		// Split up the start by the number of threads we were configured with
+		start := math.MaxUint32 / MinerConfig.Threads * i
 		go pow.Solve(block, MinerInstance.Settings.PoWDifficultyNoOpBlock, uint32(start), solved, done)
 	}
 	return done
@@ -1109,15 +1300,15 @@ func NoopJob(hash string, solved chan blockchain.Block) chan bool {
 // Initiate a job with a predefined op array
 func OpJob(hash string, Ops []blockchain.OperationInfo, solved chan blockchain.Block) chan bool {
 	CurrJobId++
-	fmt.Println("Starting job:", CurrJobId)
+	Logln(LOG_DEBUG, "Starting job:", CurrJobId)
 	block := blockchain.Block{PrevHash: hash,
 		OpHistory:   Ops,
 		MinerPubKey: utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey)}
 	done := make(chan bool)
-	for i := 0; i <= MAX_THREADS; i++ {
+	for i := 0; i < MinerConfig.Threads; i++ {
 		CurrJobId++
-		// Split up the start by the maximum number of threads we allow
-		start := math.MaxUint32 / MAX_THREADS * i
+		// Split up the start by the number of threads we were configured with
+		start := math.MaxUint32 / MinerConfig.Threads * i
 		go pow.Solve(block, MinerInstance.Settings.PoWDifficultyOpBlock, uint32(start), solved, done)
 	}
 	return done
@@ -1133,13 +1324,13 @@ func Verify(msg []byte, sign []byte, R, S big.Int, privKey *ecdsa.PrivateKey) bo
 	if hash == hex.EncodeToString(sign) && ecdsa.Verify(&privKey.PublicKey, sign, &R, &S) {
 		return true
 	} else {
-		fmt.Println("invalid access\n")
+		Logln(LOG_ERROR, "invalid access")
 		return false
 	}
 }
 func CheckError(err error, parent string) bool {
 	if err != nil {
-		fmt.Println(parent, ":: found error! ", err)
+		Logln(LOG_ERROR, parent, ":: found error! ", err)
 		return true
 	}
 	return false
@@ -1163,6 +1354,10 @@ func CheckStatusCode(err error) string {
 		return "7" + " " + err.Error()
 	case libminer.InvalidShapeHashError:
 		return "8" + " " + err.Error()
+	case libminer.OperationTimeoutError:
+		return "10" + " " + err.Error()
+	case libminer.InvalidBlockHeightError:
+		return "11" + " " + err.Error()
 	default:
 		return "9"
 	}
@@ -1185,10 +1380,22 @@ func ExtractKeyPairs(pubKey, privKey string) {
 	r, s, _ := ecdsa.Sign(rand.Reader, PrivateKey, []byte("data"))
 
 	if !ecdsa.Verify(PublicKey, []byte("data"), r, s) {
-		fmt.Println("ExtractKeyPairs:: Key pair incorrect, please recheck")
+		Logln(LOG_ERROR, "ExtractKeyPairs:: Key pair incorrect, please recheck")
 	}
 	MinerInstance.PrivKey = PrivateKey
-	fmt.Println("ExtractKeyPairs:: Key pair verified")
+	Logln(LOG_INFO, "ExtractKeyPairs:: Key pair verified")
+}
+
+// Loads the miner's identity from the key store instead of raw hex
+func LoadKeyPair(keyDir, keyName, passphrase string) error {
+	privKey, err := keystore.New(keyDir).Load(keyName, passphrase)
+	if CheckError(err, "LoadKeyPair:Load") {
+		return err
+	}
+
+	MinerInstance.PrivKey = privKey
+	Logln(LOG_INFO, "LoadKeyPair:: Loaded key", keyName, "from", keyDir)
+	return nil
 }
 
 func pubKeyToString(key ecdsa.PublicKey) string {
@@ -1221,8 +1428,12 @@ func GetBlockHashOfShapeHash(opSig string) string {
 	return ""
 }
 
+// Dumps blocks to stdout at the debug log level
 func PrintBlockChain(blocks []blockchain.Block) {
-	fmt.Println("Current amount of blocks we have: ", len(BlockHashMap))
+	if !LogEnabled(LOG_DEBUG) {
+		return
+	}
+	Logln(LOG_DEBUG, "Current amount of blocks we have: ", len(BlockHashMap))
 	for i, block := range blocks {
 		if i != 0 {
 			if len(block.PrevHash) < 6 || len(block.MinerPubKey) < 6 {
@@ -1232,19 +1443,26 @@ func PrintBlockChain(blocks []blockchain.Block) {
 			for _, opinfo := range block.OpHistory {
 				if opinfo.Op.OpType == blockchain.ADD {
 					fmt.Print("-ADD:", opinfo.Op.SVGString, ":", opinfo.OpSig,"-")
+				} else if opinfo.Op.OpType == blockchain.BATCH {
+					fmt.Print("-BATCH:", opinfo.Op.SVGString, ":", opinfo.OpSig,"-")
+				} else if opinfo.Op.OpType == blockchain.UPDATE {
+					fmt.Print("-UPDATE:", opinfo.AddSig, ">", opinfo.Op.SVGString, ":", opinfo.OpSig,"-")
 				} else {
 					fmt.Print("-DELETE:", opinfo.Op.SVGString, ":", opinfo.OpSig,"-")
 				}
 			}
 			fmt.Print(" ->\n")
 		} else {
-			fmt.Println("<- ", MinerInstance.Settings.GenesisBlockHash, " ->")
+			Logln(LOG_DEBUG, "<- ", MinerInstance.Settings.GenesisBlockHash, " ->")
 		}
 	}
-	fmt.Println("Length of the blockchain: ", len(blocks))
+	Logln(LOG_DEBUG, "Length of the blockchain: ", len(blocks))
 }
 
 func RecoverTemp() {
+	if !LogEnabled(LOG_DEBUG) {
+		return
+	}
 	p, l := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
 	fmt.Printf("Len of Blockchain Path is: %d. Path:\n", l)
 
@@ -1267,11 +1485,12 @@ func RecoverTemp() {
 func Recover() {
 	// recover from panic caused by writing to a closed channel
 	if r := recover(); r != nil {
-		fmt.Println("recovered from GetLongestPath")
+		Logln(LOG_ERROR, "recovered from GetLongestPath")
 		blockhash, _ := json.Marshal(BlockHashMap)
 		blockarray, _ := json.Marshal(BlockNodeArray)
-		ioutil.WriteFile("./output/blockhashmap.txt", blockhash, 0644)
-		ioutil.WriteFile("./output/blockhasharray.txt", blockarray, 0644)
+		os.MkdirAll(MinerConfig.DataPath("output"), 0755)
+		ioutil.WriteFile(MinerConfig.DataPath("output", "blockhashmap.txt"), blockhash, 0644)
+		ioutil.WriteFile(MinerConfig.DataPath("output", "blockhasharray.txt"), blockarray, 0644)
 		return
 	}
 }
@@ -1295,23 +1514,41 @@ func GeneratePublicIP() string {
 /*******************************
 | Main
 ********************************/
+// Kept for callers that still pass the hex key pair around.
+// See MineWithConfig for the configurable version.
 func Mine(serverIP, pubKey, privKey string) {
+	config := DefaultConfig()
+	config.ServerAddr = serverIP
+	config.PubKey, config.PrivKey = pubKey, privKey
+	MineWithConfig(config, "")
+}
+
+// Same as Mine, but the identity is loaded by name from the key store
+func MineWithKeyStore(serverIP, keyDir, keyName, passphrase string) {
+	config := DefaultConfig()
+	config.ServerAddr = serverIP
+	config.KeyDir, config.KeyName = keyDir, keyName
+	MineWithConfig(config, passphrase)
+}
+
+// Starts every miner routine once MinerInstance has its key pair
+func startMiner(serverIP string) {
 	gob.Register(&net.TCPAddr{})
 	gob.Register(&elliptic.CurveParams{})
-	// serverIP, pubKey, privKey := os.Args[1], os.Args[2], os.Args[3]
-	serverIP = os.Args[1]
 
 	BlockCond = &sync.Cond{L: &sync.Mutex{}}
 
-	// 1. Setup the singleton miner instance
-	MinerInstance = new(Miner)
-	// Extract key pairs
-	ExtractKeyPairs(pubKey, privKey)
 	// Listening Address
-	publicIP := GeneratePublicIP()
-	fmt.Println(publicIP)
+	listenAddr := MinerConfig.ListenAddr
+	if listenAddr == "" {
+		listenAddr = GeneratePublicIP()
+	}
+	Logf(LOG_INFO, "startMiner:: miner listen address: %s", listenAddr)
 
-	ln, _ := net.Listen("tcp", publicIP)
+	ln, err := net.Listen("tcp", listenAddr)
+	if CheckError(err, "startMiner:Listen") {
+		os.Exit(1)
+	}
 	addr := ln.Addr()
 	MinerInstance.Addr = addr
 
@@ -1322,6 +1559,15 @@ func Mine(serverIP, pubKey, privKey string) {
 	sblock := make(chan blockchain.Block, 1024)
 	peerconn := make(chan net.Addr, 64)
 
+	// Peers known from earlier runs and the seed list, see addrbook.go
+	book, err := LoadAddressBook(MinerConfig.DataPath(ADDRESS_BOOK_FILE))
+	CheckError(err, "startMiner:LoadAddressBook")
+	Book = book
+	for _, seed := range MinerConfig.Seeds {
+		addr, key := parseSeed(seed)
+		Book.Add(addr, key, SOURCE_SEED)
+	}
+
 	// 3. Setup Miner-Miner Listener
 	go listenPeerRpc(ln, MinerInstance, pop, pblock, sop, sblock, peerconn)
 
@@ -1348,6 +1594,10 @@ func Mine(serverIP, pubKey, privKey string) {
 	// 5. Setup Problem Solving
 	go ProblemSolver(sop, sblock, pblock)
 
-	// 6. Setup Client-Miner Listener (this thread)
-	OpenLibMinerConn(":0", pop, sop)
+	// 6. Track ops submitted by our art node
+	Tracker = NewOpTracker(pop, sop)
+	go Tracker.Run()
+
+	// 7. Setup Client-Miner Listener (this thread)
+	OpenLibMinerConn(MinerConfig.ArtNodeListenAddr, pop, sop)
 }
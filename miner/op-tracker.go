@@ -0,0 +1,248 @@
+/*
+
+This file contains the tracker for operations submitted by the art node
+connected to this miner.
+
+Every submitted op is re-evaluated against the longest chain whenever a block
+is inserted. The tracker takes over what Draw and Delete used to do in their
+own wait loops: republishing ops that aren't picked up, noticing rejections,
+and counting confirmations. Draw and Delete now just wait on the tracker.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"../blockchain"
+	"../libminer"
+)
+
+const (
+	// How long finished (rejected or confirmed) ops are remembered for
+	// GetOpStatus, from when they finished
+	OP_STATUS_TTL = 1 * time.Hour
+	// How long ops that never finish are tracked, from when they were
+	// submitted
+	OP_PENDING_TTL = 24 * time.Hour
+)
+
+// The tracker for this miner, started in startMiner
+var Tracker *OpTracker
+
+// A single submitted operation
+type trackedOp struct {
+	OpInfo blockchain.OperationInfo
+	Status libminer.OpStatusResponse
+	// Validation error if Status.State is OP_REJECTED
+	Err error
+	// Blocks seen since the op was last (re)propagated
+	blocksSincePropagate int
+	// Closed and replaced every time Status changes
+	changed   chan struct{}
+	submitted time.Time
+	// When the op was rejected or included, zero while it is pending
+	finished time.Time
+}
+
+type OpTracker struct {
+	sync.Mutex
+	ops map[string]*trackedOp
+	pop chan PropagateOpArgs
+	sop chan blockchain.OperationInfo
+}
+
+func NewOpTracker(pop chan PropagateOpArgs, sop chan blockchain.OperationInfo) *OpTracker {
+	return &OpTracker{ops: make(map[string]*trackedOp), pop: pop, sop: sop}
+}
+
+// Starts tracking opInfo and sends it to our peers and problem solver
+func (t *OpTracker) Submit(opInfo blockchain.OperationInfo) {
+	t.Lock()
+	t.ops[opInfo.OpSig] = &trackedOp{
+		OpInfo:    opInfo,
+		Status:    libminer.OpStatusResponse{State: libminer.OP_PENDING},
+		changed:   make(chan struct{}),
+		submitted: time.Now()}
+	t.Unlock()
+
+	t.propagate(opInfo, false)
+}
+
+// Returns the current status of the op with the given shape hash
+func (t *OpTracker) Status(shapeHash string) (libminer.OpStatusResponse, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if op, ok := t.ops[shapeHash]; ok {
+		return op.Status, true
+	}
+	return libminer.OpStatusResponse{}, false
+}
+
+// Blocks until the op has validateNum confirmations, is rejected, or timeout
+// runs out.
+// Possible Errors:
+// - The validation error of a rejected op
+// - OperationTimeoutError
+// - InvalidShapeHashError if the op isn't tracked
+func (t *OpTracker) Wait(shapeHash string, validateNum uint8, timeout time.Duration) (libminer.OpStatusResponse, error) {
+	deadline := time.After(timeout)
+	for {
+		t.Lock()
+		op, ok := t.ops[shapeHash]
+		if !ok {
+			t.Unlock()
+			return libminer.OpStatusResponse{}, errors.New(CheckStatusCode(libminer.InvalidShapeHashError(shapeHash)))
+		}
+
+		status, opErr, changed := op.Status, op.Err, op.changed
+		t.Unlock()
+
+		switch status.State {
+		case libminer.OP_REJECTED:
+			return status, opErr
+		case libminer.OP_INCLUDED:
+			if status.Confirmations >= int(validateNum) {
+				return status, nil
+			}
+			Logf(LOG_DEBUG, "Not enough blocks to validate yet: %d", status.Confirmations)
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			code := CheckStatusCode(libminer.OperationTimeoutError(shapeHash))
+			return status, errors.New(code)
+		}
+	}
+}
+
+// Re-evaluates every tracked op each time a block is inserted
+func (t *OpTracker) Run() {
+	for {
+		BlockCond.L.Lock()
+		BlockCond.Wait()
+		BlockCond.L.Unlock()
+
+		t.update()
+	}
+}
+
+func (t *OpTracker) update() {
+	t.Lock()
+	ops := make([]*trackedOp, 0, len(t.ops))
+	for shapeHash, op := range t.ops {
+		if op.expired() {
+			delete(t.ops, shapeHash)
+			continue
+		}
+
+		// Included ops are still checked for confirmations and reorgs
+		if op.Status.State != libminer.OP_REJECTED {
+			ops = append(ops, op)
+		}
+	}
+	t.Unlock()
+
+	if len(ops) == 0 {
+		return
+	}
+
+	// Evaluate everything against one snapshot of the longest chain so an op
+	// that lands in a block halfway through isn't seen as a duplicate
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+
+	for _, op := range ops {
+		t.Lock()
+		prev := op.Status
+		opInfo := op.OpInfo
+		t.Unlock()
+
+		status := prev
+		var opErr error
+		repropagate := false
+
+		if blockHash, confirmations := findOpInChain(chain, opInfo.OpSig); blockHash != "" {
+			status = libminer.OpStatusResponse{
+				State:         libminer.OP_INCLUDED,
+				BlockHash:     blockHash,
+				Confirmations: confirmations,
+				InkRemaining:  uint32(CalculateInk(opInfo.PubKey))}
+		} else if prev.State == libminer.OP_INCLUDED {
+			// The block holding it is no longer on the longest chain
+			Logf(LOG_INFO, "OpTracker:: op %s was reorged out of %s", opInfo.OpSig, prev.BlockHash)
+			status = libminer.OpStatusResponse{State: libminer.OP_REORGED}
+			repropagate = true
+		} else {
+			validateLock.Lock()
+			opErr = validateOpInfo(opInfo, chain)
+			validateLock.Unlock()
+
+			if _, dup := opErr.(DuplicateError); opErr != nil && !dup {
+				status = libminer.OpStatusResponse{State: libminer.OP_REJECTED, Reason: CheckStatusCode(opErr)}
+			} else {
+				opErr = nil
+				t.Lock()
+				op.blocksSincePropagate++
+				if op.blocksSincePropagate > BLOCKS_BEFORE_REPROPAGATE {
+					Logf(LOG_INFO, "OpTracker:: op %s not picked up yet - republishing", opInfo.OpSig)
+					op.blocksSincePropagate = 0
+					repropagate = true
+				}
+				t.Unlock()
+			}
+		}
+
+		if repropagate {
+			t.propagate(opInfo, true)
+		}
+
+		t.Lock()
+		op.Err = opErr
+		if status != prev {
+			op.Status = status
+			if status.State == libminer.OP_REJECTED || status.State == libminer.OP_INCLUDED {
+				if op.finished.IsZero() {
+					op.finished = time.Now()
+				}
+			} else {
+				op.finished = time.Time{}
+			}
+			close(op.changed)
+			op.changed = make(chan struct{})
+		}
+		t.Unlock()
+	}
+}
+
+// Whether the op can be forgotten. Must be called with the tracker locked.
+func (op *trackedOp) expired() bool {
+	if op.finished.IsZero() {
+		return time.Since(op.submitted) > OP_PENDING_TTL
+	}
+	return time.Since(op.finished) > OP_STATUS_TTL
+}
+
+// Sends opInfo to our peers and problem solver. resend is set when it is
+// republished.
+func (t *OpTracker) propagate(opInfo blockchain.OperationInfo, resend bool) {
+	t.pop <- PropagateOpArgs{OpInfo: opInfo, Resend: resend}
+	t.sop <- opInfo
+}
+
+// Returns the hash of the block in chain holding opSig and the number of
+// blocks following it, or "" if opSig isn't in chain
+func findOpInChain(chain []blockchain.Block, opSig string) (blockHash string, confirmations int) {
+	for i, block := range chain {
+		for _, opInfo := range block.OpHistory {
+			if opInfo.OpSig == opSig {
+				return GetBlockHash(block), len(chain) - 1 - i
+			}
+		}
+	}
+	return "", 0
+}
@@ -6,20 +6,20 @@ This file contains the following:
 3. Function to initialize the miner peer listener
 
 Peer RPC calls:
-  Connect(args *connectArgs, reply *empty)
+  Connect(args Handshake, reply *Handshake)
   Hb(args *empty, reply *empty)
-  PropagateOp(args *propagateOpArgs, reply *empty)
-  PropagateBlock(args *propagateBlockArgs, reply *empty)
-  GetBlockChain(args *empty, reply *getBlockChainArgs)
+
+The handshake exchanged by Connect is in handshake.go, gossip calls
+(Announce, Deliver) in gossip.go, chain synchronization calls (GetHeaders,
+GetBlocks) in sync.go.
 
 */
 
 package miner
 
 import (
-	"fmt"
+	"crypto/ecdsa"
 	"net"
-	"net/rpc"
 	"sync"
 	"log"
 
@@ -40,28 +40,27 @@ type PeerRpc struct {
 	opSCh  chan blockchain.OperationInfo
 	blkSCh chan blockchain.Block
 	reqCh  chan net.Addr
-	blks   map[string]Empty
+
+	// Key the miner on the other side of the connection proved it owns, and
+	// the address it registered that key for once it called Connect
+	peerKey  *ecdsa.PublicKey
+	peerAddr string
+	addrLock sync.Mutex
 }
 
 // Empty struct. Use for filling required but unused function parameters.
 type Empty struct{}
 
-type ConnectArgs struct {
-	Addr net.Addr
-}
-
+// An op to announce to our peers
 type PropagateOpArgs struct {
 	OpInfo blockchain.OperationInfo
-	TTL    int
+	// Announce it even to peers that have seen it, see InvItem.Resend
+	Resend bool
 }
 
+// A block to announce to our peers
 type PropagateBlockArgs struct {
 	Block blockchain.Block
-	TTL   int
-}
-
-type GetBlockChainArgs struct {
-	blockChain []blockchain.Block
 }
 
 /***********************
@@ -71,20 +70,27 @@ type GetBlockChainArgs struct {
 // Adds the connecting peer to the list of maintained peers. The peer
 // requesting connect will be added to the maintained peer count. There will
 // be a heartbeat procedure for it, and any data propagations will be sent to
-// the peer as well.
-func (p *PeerRpc) Connect(args ConnectArgs, reply *[]blockchain.Block) error {
+// the peer as well. The peer syncs its chain with GetHeaders afterwards.
+// Peers with an incompatible handshake, or whose key isn't the one registered
+// for their address, are refused.
+func (p *PeerRpc) Connect(args Handshake, reply *Handshake) error {
+	if args.Addr != nil && IsBanned(args.Addr.String()) {
+		return BannedPeerError(args.Addr.String())
+	}
+	if err := checkHandshake(args); err != nil {
+		Logln(LOG_INFO, "Connect refused from", args.Addr, ":", err)
+		return err
+	}
+	if err := p.authenticate(args.Addr); err != nil {
+		Logln(LOG_INFO, "Connect refused from", args.Addr, ":", err)
+		return err
+	}
 
 	// - Send through request channel to Connection Manager to connect next time
 	log.Printf("write to ch")
 	p.reqCh <- args.Addr
-	blockchain := make([]blockchain.Block, 0)
-	for i, node := range BlockNodeArray {
-		if i != 0 {
-			blockchain = append(blockchain, node.Block)
-		}
-	}
-	*reply = blockchain
-	fmt.Println("Connect called by: ", args.Addr.String())
+	*reply = localHandshake()
+	Logln(LOG_INFO, "Connect called by: ", args.Addr.String())
 
 	return nil
 }
@@ -97,41 +103,45 @@ func (p *PeerRpc) Hb(args *Empty, reply *Empty) error {
 
 // Get a shape interface from an operation.
 func (m Miner) getShapeFromOp(op blockchain.Operation) (shapelib.Shape, error) {
-	pathlist, parsingErr := utils.GetParsedSVG(op.SVGString)
-	if parsingErr == nil {
-		// Error is nil, should be parsable into shapelib.Path
-		return utils.SVGToPoints(pathlist,
-			int(m.Settings.CanvasSettings.CanvasXMax),
-			int(m.Settings.CanvasSettings.CanvasXMax),
-			op.Fill != "transparent",
-			op.Stroke != "transparent")
-	}
+	return utils.GetShape(op,
+		int(m.Settings.CanvasSettings.CanvasXMax),
+		int(m.Settings.CanvasSettings.CanvasYMax))
+}
 
-	// Try parsing it as a circle
-	circ, err := utils.GetParsedCirc(op,
+// Get every shape drawn by an operation. A batch has one per member.
+func (m Miner) getShapesFromOp(op blockchain.Operation) ([]shapelib.Shape, error) {
+	return utils.GetShapes(op,
 		int(m.Settings.CanvasSettings.CanvasXMax),
-		int(m.Settings.CanvasSettings.CanvasXMax))
+		int(m.Settings.CanvasSettings.CanvasYMax))
+}
+
+// Get the ink an operation costs, or refunds if it is a DELETE
+func (m Miner) opCost(op blockchain.Operation) (int, error) {
+	shapes, err := m.getShapesFromOp(op)
 	if err != nil {
-		fmt.Println("SVG string is neither circle nor path:", op.SVGString)
-		return circ, parsingErr
+		return 0, err
 	}
 
-	// FIXME: change for circle
-	return circ, nil
+	total := 0
+	for _, shape := range shapes {
+		_, cost := shape.SubArrayAndCost()
+		total += cost
+	}
+	return total, nil
 }
 
 // Get a shapelib.Path from an operation
 func (m Miner) getPathFromOp(op blockchain.Operation) (shapelib.Path, error) {
 	pathlist, err := utils.GetParsedSVG(op.SVGString)
 	if err != nil {
-		fmt.Println("PropagateOp err:", err)
+		Logln(LOG_ERROR, "PropagateOp err:", err)
 		path := shapelib.NewPath(nil, false, false)
 		return path, err
 	}
 
 	// Get the shapelib.Path representation for this svg path
 	return utils.SVGToPoints(pathlist, int(m.Settings.CanvasSettings.CanvasXMax),
-		int(m.Settings.CanvasSettings.CanvasXMax), op.Fill != "transparent",
+		int(m.Settings.CanvasSettings.CanvasYMax), op.Fill != "transparent",
 		op.Stroke != "transparent")
 }
 
@@ -140,32 +150,24 @@ func (m Miner) getPathFromOp(op blockchain.Operation) (shapelib.Path, error) {
 // of multiple, conflicting operations.
 var validateLock sync.Mutex
 
-// This RPC is used to send an operation (addshape, deleteshape) to miners.
-// Will not return any useful information.
-func (p *PeerRpc) PropagateOp(args PropagateOpArgs, reply *Empty) error {
-	fmt.Println("PropagateOp called")
+// Handles an operation (addshape, deleteshape) delivered by a peer. Valid
+// ops go to the solver and are announced to our peers. Ops that are invalid
+// whatever the chain return an InvalidOpError.
+func (p *PeerRpc) receiveOp(opInfo blockchain.OperationInfo) error {
+	Logln(LOG_DEBUG, "receiveOp called")
 
-	// TODO: Validate the shapehash using the public key
-
-	// Get the shapelib.Shape representation for this svg
-	shape, err := p.miner.getShapeFromOp(args.OpInfo.Op)
-	if err != nil {
+	if err := checkOpStateless(opInfo); err != nil {
 		return err
 	}
 
-	subarr, inkRequired := shape.SubArrayAndCost()
-
 	validateLock.Lock()
 
+	// Adds and batches are checked for ink and overlaps, deletes for
+	// ownership
 	blocks, _ := GetLongestPath(p.miner.Settings.GenesisBlockHash)
-	if args.OpInfo.Op.OpType == blockchain.ADD {
-		err = p.miner.checkInkAndConflicts(subarr, inkRequired, args.OpInfo.PubKey, blocks, args.OpInfo.Op.SVGString, args.OpInfo.OpSig)
-	} else {
-		fmt.Println("Checking deletion")
-		err = p.miner.checkDeletion(args.OpInfo.AddSig, args.OpInfo.PubKey, blocks)
-		if err != nil {
-			fmt.Println("DELETE WAS BAD!!!")
-		}
+	err := validateOpInfo(opInfo, blocks)
+	if err != nil && opInfo.Op.OpType == blockchain.DELETE {
+		Logln(LOG_ERROR, "DELETE WAS BAD!!!")
 	}
 	validateLock.Unlock()
 
@@ -175,57 +177,37 @@ func (p *PeerRpc) PropagateOp(args PropagateOpArgs, reply *Empty) error {
 
 	// Update the solver. There will likely need to be additional logic somewhere here.
 	log.Printf("write to ch")
-	p.opSCh <- args.OpInfo
+	p.opSCh <- opInfo
 
-	// Propagate op to list of connected peers.
-	args.TTL--
-	if args.TTL > 0 {
-		log.Printf("write to ch")
-		p.opCh <- args
-	}
+	// Announce op to list of connected peers.
+	log.Printf("write to ch")
+	p.opCh <- PropagateOpArgs{OpInfo: opInfo}
 
 	return nil
 }
 
-var msgLock sync.Mutex
-
-// This RPC is used to send a new block (addshape, deleteshape) to miners.
-// Will not return any useful information.
-func (p *PeerRpc) PropagateBlock(args PropagateBlockArgs, reply *Empty) error {
-	//fmt.Println("PropagateBlock called")
-	msgLock.Lock()
-	blkHash := GetBlockHash(args.Block)
-	if _, exists := p.blks[blkHash]; exists {
-		//fmt.Println("Ignoring already received blockhash")
-		msgLock.Unlock()
-		return nil
-	} else {
-		p.blks[blkHash] = Empty{}
-		msgLock.Unlock()
-	}
-
+// Handles a new block delivered by a peer. Valid blocks are inserted and
+// announced to our peers. Returns whether the block was valid.
+func (p *PeerRpc) receiveBlock(block blockchain.Block) bool {
 	// Find the path that the block should be on, no guarantee it is the longest
-	path := GetPath(args.Block.PrevHash)
+	path := GetPath(block.PrevHash)
 
 	// Validate the block, if the block is not valid just drop it
 	validateLock.Lock()
-	ok := p.miner.ValidateBlock(args.Block, path)
+	ok := p.miner.ValidateBlock(block, path)
 	validateLock.Unlock()
 
 	if ok {
-		// Propagate block to list of connected peers. Too lazy to get rid of TTL;
-		// it's not used any more for PropgateBlock though.
-		if args.TTL > 0 {
-			log.Printf("write to ch")
-			p.blkCh <- args
-		}
-
 		// Snapshot the current longest path
 		longest, length := GetLongestPath(p.miner.Settings.GenesisBlockHash)
 		lastblock := longest[length-1]
 
 		// - Add block to block chain.
-		InsertBlock(args.Block)
+		InsertBlock(block)
+
+		// Announce block to list of connected peers.
+		log.Printf("write to ch")
+		p.blkCh <- PropagateBlockArgs{Block: block}
 
 		// Check if the longest path changed
 		newlongest, newlength := GetLongestPath(p.miner.Settings.GenesisBlockHash)
@@ -233,42 +215,21 @@ func (p *PeerRpc) PropagateBlock(args PropagateBlockArgs, reply *Empty) error {
 
 		// If the longest path changed we should build off of it so send it to problem solver
 		if newlength >= length && newlastblock.Nonce != lastblock.Nonce && newlastblock.MinerPubKey != lastblock.MinerPubKey {
-			fmt.Println("Propgation:", args.TTL)
-			p.blkSCh <- args.Block
+			p.blkSCh <- block
 		}
 	}
-
-	return nil
-}
-
-// This RPC is used for peers to get latest information when they are newly
-// initalized. No useful argument.
-func (p *PeerRpc) GetBlockChain(args Empty, reply *[]blockchain.Block) error {
-	fmt.Println("GetBlockChain called")
-
-	chain := make([]blockchain.Block, 0)
-	for i, node := range BlockNodeArray {
-		if i != 0 {
-			chain = append(chain, node.Block)
-		}
-	}
-	*reply = chain
-
-	return nil
+	return ok
 }
 
 // This will initialize the miner peer listener.
 func listenPeerRpc(ln net.Listener, miner *Miner, opCh chan PropagateOpArgs,
 	blkCh chan PropagateBlockArgs, opSCh chan blockchain.OperationInfo,
 	blkSCh chan blockchain.Block, reqCh chan net.Addr) {
-	pRpc := PeerRpc{miner, opCh, blkCh, opSCh, blkSCh, reqCh, make(map[string]Empty)}
-
-	fmt.Println("listenPeerRpc::listening on: ", ln.Addr().String())
+	pRpc := &PeerRpc{miner: miner, opCh: opCh, blkCh: blkCh, opSCh: opSCh, blkSCh: blkSCh, reqCh: reqCh}
 
-	server := rpc.NewServer()
-	server.RegisterName("Peer", &pRpc)
+	Logln(LOG_INFO, "listenPeerRpc::listening on: ", ln.Addr().String())
 
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	server.Accept(ln)
+	servePeers(ln, pRpc)
 }
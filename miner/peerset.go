@@ -0,0 +1,251 @@
+/*
+
+This file contains the peer set and the per-peer goroutines.
+
+Every peer we connect to gets a goroutine of its own that runs the calls
+queued for it one after another, so a peer that hangs only holds up its own
+queue. Queues are bounded: when a peer falls PEER_QUEUE_SIZE calls behind,
+new calls for it are dropped (it catches up with the next sync) instead of
+blocking the caller. Every call has a deadline. A call that misses it
+closes the connection, which releases the call still waiting on it, so a peer
+that times out is dropped; so is a peer that misses its heartbeats, by
+CheckLiveliness.
+
+PeerList is a PeerSet, safe to use from any goroutine. Connections being set
+up are reserved in it so the same address isn't dialed twice and MAX_PEERS
+holds while dials are in flight.
+
+*/
+
+package miner
+
+import (
+	"fmt"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+const (
+	// Calls queued per peer before new ones are dropped
+	PEER_QUEUE_SIZE = 256
+	// Deadline of a call to a peer or the server
+	CALL_TIMEOUT = 10 * time.Second
+	// Deadline of the calls that carry many headers or blocks
+	SYNC_CALL_TIMEOUT = 60 * time.Second
+)
+
+// Contains the method that timed out
+type TimeoutError string
+
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("Call timed out: %s", string(e))
+}
+
+// Contains the address of a peer whose queue is full
+type QueueFullError string
+
+func (e QueueFullError) Error() string {
+	return fmt.Sprintf("Peer queue full: %s", string(e))
+}
+
+type PeerSet struct {
+	sync.RWMutex
+	peers map[string]*Peer
+	// Addresses being connected to
+	pending map[string]bool
+}
+
+func NewPeerSet() *PeerSet {
+	return &PeerSet{peers: make(map[string]*Peer), pending: make(map[string]bool)}
+}
+
+// Returns a new peer and starts its goroutine
+func NewPeer(addr string, client *rpc.Client, handshake Handshake) *Peer {
+	peer := &Peer{
+		Addr:          addr,
+		Client:        client,
+		Handshake:     handshake,
+		lastHeartBeat: time.Now(),
+		queue:         make(chan func(*Peer), PEER_QUEUE_SIZE),
+		quit:          make(chan struct{})}
+	go peer.run()
+	return peer
+}
+
+// Runs the queued calls until the peer is closed
+func (p *Peer) run() {
+	for {
+		select {
+		case task := <-p.queue:
+			task(p)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Queues task to run on the peer's goroutine. Never blocks: returns
+// QueueFullError if the peer is too far behind, and drops the task.
+func (p *Peer) Send(task func(*Peer)) error {
+	select {
+	case <-p.quit:
+		return rpc.ErrShutdown
+	default:
+	}
+
+	select {
+	case p.queue <- task:
+		return nil
+	default:
+		return QueueFullError(p.Addr)
+	}
+}
+
+// Calls method on the peer, giving up after timeout. The peer is dropped if
+// it times out, since callWithTimeout closed its connection.
+func (p *Peer) Call(method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	err := callWithTimeout(p.Client, method, args, reply, timeout)
+	if _, timedOut := err.(TimeoutError); timedOut {
+		Logf(LOG_INFO, "Call:: %s timed out on %s, dropping it", method, p.Addr)
+		dropPeer(p)
+	}
+	return err
+}
+
+// Records that the peer just answered
+func (p *Peer) Touch() {
+	p.mutex.Lock()
+	p.lastHeartBeat = time.Now()
+	p.mutex.Unlock()
+}
+
+// Returns when the peer last answered
+func (p *Peer) LastHeartBeat() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.lastHeartBeat
+}
+
+// Stops the peer's goroutine and closes its connection. Queued calls are
+// dropped.
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.Client.Close()
+	})
+}
+
+// Calls method on client, giving up after timeout. On a timeout client is
+// closed: net/rpc can't cancel a call, and closing makes the pending one fail
+// with rpc.ErrShutdown instead of holding its reply and goroutine until the
+// other side answers, if ever. The caller has to dial again.
+func callWithTimeout(client *rpc.Client, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		client.Close()
+		return TimeoutError(method)
+	}
+}
+
+// Reserves addr for a new connection. Returns false if it is already
+// connected or being connected to, or if MAX_PEERS would be exceeded.
+func (s *PeerSet) Reserve(addr string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.peers[addr]; ok || s.pending[addr] {
+		return false
+	}
+	if len(s.peers)+len(s.pending) >= MAX_PEERS {
+		return false
+	}
+	s.pending[addr] = true
+	return true
+}
+
+// Gives up a reservation made with Reserve
+func (s *PeerSet) Release(addr string) {
+	s.Lock()
+	delete(s.pending, addr)
+	s.Unlock()
+}
+
+// Adds the peer for a reserved address
+func (s *PeerSet) Add(peer *Peer) {
+	s.Lock()
+	delete(s.pending, peer.Addr)
+	s.peers[peer.Addr] = peer
+	s.Unlock()
+}
+
+// Removes peer if it is still the one connected at its address, and reports
+// whether it was
+func (s *PeerSet) RemovePeer(peer *Peer) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.peers[peer.Addr] != peer {
+		return false
+	}
+	delete(s.peers, peer.Addr)
+	return true
+}
+
+// Removes and returns the peer at addr, or nil if there is none
+func (s *PeerSet) Remove(addr string) *Peer {
+	s.Lock()
+	defer s.Unlock()
+
+	peer := s.peers[addr]
+	delete(s.peers, addr)
+	return peer
+}
+
+func (s *PeerSet) Get(addr string) (*Peer, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	peer, ok := s.peers[addr]
+	return peer, ok
+}
+
+// Number of connected peers
+func (s *PeerSet) Len() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.peers)
+}
+
+// Number of connected peers plus the ones being connected to
+func (s *PeerSet) Count() int {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.peers) + len(s.pending)
+}
+
+// Returns a copy of the connected peers, by address, to iterate over
+func (s *PeerSet) Snapshot() map[string]*Peer {
+	s.RLock()
+	defer s.RUnlock()
+
+	peers := make(map[string]*Peer, len(s.peers))
+	for addr, peer := range s.peers {
+		peers[addr] = peer
+	}
+	return peers
+}
+
+// Queues task on every connected peer, logging the ones that are too far
+// behind
+func (s *PeerSet) SendAll(task func(*Peer)) {
+	for addr, peer := range s.Snapshot() {
+		CheckError(peer.Send(task), "SendAll:"+addr)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+
+This file contains the region queries for art nodes: the shapes that cover a
+rectangle or a single pixel of the current canvas.
+
+Queries are answered from a grid index over the live shapes at the tip of the
+longest chain. The index is rebuilt the first time it is queried after the
+tip changes, and shared by all queries until then.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"../blockchain"
+	"../libminer"
+	"../shapelib"
+)
+
+const (
+	// Side of a grid cell of the shape index, in pixels
+	INDEX_CELL_SIZE = 32
+)
+
+// A live shape and its pixels
+type indexedShape struct {
+	shape  libminer.RegionShape
+	pixels []shapelib.PixelSubArray
+}
+
+// Grid index over the live shapes at BlockHash. Every cell lists the shapes
+// whose bounding box touches it, in the order they were added.
+type ShapeIndex struct {
+	BlockHash string
+	shapes    []indexedShape
+	cells     map[[2]int][]int
+}
+
+var shapeIndex struct {
+	sync.Mutex
+	index *ShapeIndex
+}
+
+// Returns the shapes covering at least one pixel of RegionRequest.Region
+func (lmi *LibMinerInterface) GetShapesInRegion(req *libminer.Request, response *libminer.RegionResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var regionReq libminer.RegionRequest
+		json.Unmarshal(req.Msg, &regionReq)
+
+		r := regionReq.Region
+		if r.XMin > r.XMax || r.YMin > r.YMax || !MinerInstance.onCanvas(r.XMin, r.YMin) ||
+			!MinerInstance.onCanvas(r.XMax, r.YMax) {
+			return errors.New(CheckStatusCode(libminer.OutOfBoundsError{}))
+		}
+
+		index := CurrentShapeIndex()
+		response.BlockHash = index.BlockHash
+		response.Shapes = index.InRegion(r)
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Returns the shapes covering the pixel at PointRequest.X, Y, topmost first
+func (lmi *LibMinerInterface) GetShapesAtPoint(req *libminer.Request, response *libminer.RegionResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var pointReq libminer.PointRequest
+		json.Unmarshal(req.Msg, &pointReq)
+
+		if !MinerInstance.onCanvas(pointReq.X, pointReq.Y) {
+			return errors.New(CheckStatusCode(libminer.OutOfBoundsError{}))
+		}
+
+		index := CurrentShapeIndex()
+		response.BlockHash = index.BlockHash
+		response.Shapes = index.AtPoint(pointReq.X, pointReq.Y)
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Returns the index for the tip of the longest chain, building it if the tip
+// moved since the last query
+func CurrentShapeIndex() *ShapeIndex {
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	return ShapeIndexAt(chain)
+}
+
+// Returns the index for the end of chain. Only the latest index is kept.
+func ShapeIndexAt(chain []blockchain.Block) *ShapeIndex {
+	hashes := ChainHashes(chain)
+	tip := hashes[len(hashes)-1]
+
+	shapeIndex.Lock()
+	defer shapeIndex.Unlock()
+
+	if shapeIndex.index == nil || shapeIndex.index.BlockHash != tip {
+		index := &ShapeIndex{BlockHash: tip, cells: make(map[[2]int][]int)}
+		for _, canvasShape := range CanvasShapes(chain, hashes) {
+			index.add(canvasShape)
+		}
+		shapeIndex.index = index
+	}
+	return shapeIndex.index
+}
+
+// Returns the shapes covering at least one pixel of r, in the order they
+// were added
+func (index *ShapeIndex) InRegion(r libminer.Rect) []libminer.RegionShape {
+	candidates := make(map[int]bool)
+	for cx := r.XMin / INDEX_CELL_SIZE; cx <= r.XMax/INDEX_CELL_SIZE; cx++ {
+		for cy := r.YMin / INDEX_CELL_SIZE; cy <= r.YMax/INDEX_CELL_SIZE; cy++ {
+			for _, i := range index.cells[[2]int{cx, cy}] {
+				candidates[i] = true
+			}
+		}
+	}
+
+	shapes := make([]libminer.RegionShape, 0)
+	for i, s := range index.shapes {
+		if candidates[i] && s.coversAny(r) {
+			shapes = append(shapes, s.shape)
+		}
+	}
+	return shapes
+}
+
+// Returns the shapes covering the pixel at (x, y), topmost first
+func (index *ShapeIndex) AtPoint(x, y int) []libminer.RegionShape {
+	cell := index.cells[[2]int{x / INDEX_CELL_SIZE, y / INDEX_CELL_SIZE}]
+
+	shapes := make([]libminer.RegionShape, 0)
+	for j := len(cell) - 1; j >= 0; j-- {
+		if s := index.shapes[cell[j]]; s.hasPixel(x, y) {
+			shapes = append(shapes, s.shape)
+		}
+	}
+	return shapes
+}
+
+// Returns the shapes not owned by owner that share a pixel with subarrs, in
+// the order they were added, along with the bounding box and the number of
+// the shared pixels
+func (index *ShapeIndex) Overlaps(subarrs []shapelib.PixelSubArray, owner string) (shapeHashes []string,
+	overlap libminer.Rect, pixels int) {
+	shapeHashes = make([]string, 0)
+	overlapping := make(map[int]bool)
+
+	for _, sub := range subarrs {
+		sub.ForEachPixel(func(x, y int) {
+			covered := false
+			for _, i := range index.cells[[2]int{x / INDEX_CELL_SIZE, y / INDEX_CELL_SIZE}] {
+				s := index.shapes[i]
+				if s.shape.Owner == owner || !s.hasPixel(x, y) {
+					continue
+				}
+				overlapping[i] = true
+				covered = true
+			}
+			if !covered {
+				return
+			}
+
+			if pixels == 0 {
+				overlap = libminer.Rect{XMin: x, YMin: y, XMax: x, YMax: y}
+			}
+			extend(&overlap, x, y)
+			pixels++
+		})
+	}
+
+	for i, s := range index.shapes {
+		if overlapping[i] {
+			shapeHashes = append(shapeHashes, s.shape.ShapeHash)
+		}
+	}
+	return shapeHashes, overlap, pixels
+}
+
+func (index *ShapeIndex) add(canvasShape libminer.CanvasShape) {
+	opShapes, err := MinerInstance.getShapesFromOp(canvasShape.Op)
+	if err != nil {
+		return
+	}
+
+	s := indexedShape{shape: libminer.RegionShape{CanvasShape: canvasShape}}
+	empty := true
+	for _, opShape := range opShapes {
+		sub := opShape.SubArray()
+		s.pixels = append(s.pixels, sub)
+		sub.ForEachPixel(func(x, y int) {
+			if empty {
+				s.shape.Bounds = libminer.Rect{XMin: x, YMin: y, XMax: x, YMax: y}
+				empty = false
+			}
+			extend(&s.shape.Bounds, x, y)
+		})
+	}
+	if empty {
+		return
+	}
+
+	i := len(index.shapes)
+	index.shapes = append(index.shapes, s)
+
+	b := s.shape.Bounds
+	for cx := b.XMin / INDEX_CELL_SIZE; cx <= b.XMax/INDEX_CELL_SIZE; cx++ {
+		for cy := b.YMin / INDEX_CELL_SIZE; cy <= b.YMax/INDEX_CELL_SIZE; cy++ {
+			cell := [2]int{cx, cy}
+			index.cells[cell] = append(index.cells[cell], i)
+		}
+	}
+}
+
+// Check if any pixel of the shape lies within r
+func (s indexedShape) coversAny(r libminer.Rect) bool {
+	b := s.shape.Bounds
+	if b.XMax < r.XMin || b.XMin > r.XMax || b.YMax < r.YMin || b.YMin > r.YMax {
+		return false
+	}
+
+	// Only the part of the bounding box inside r is scanned
+	for y := maxInt(b.YMin, r.YMin); y <= minInt(b.YMax, r.YMax); y++ {
+		for x := maxInt(b.XMin, r.XMin); x <= minInt(b.XMax, r.XMax); x++ {
+			if s.hasPixel(x, y) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s indexedShape) hasPixel(x, y int) bool {
+	for _, sub := range s.pixels {
+		if sub.HasPixel(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grows r to include (x, y)
+func extend(r *libminer.Rect, x, y int) {
+	r.XMin = minInt(r.XMin, x)
+	r.YMin = minInt(r.YMin, y)
+	r.XMax = maxInt(r.XMax, x)
+	r.YMax = maxInt(r.YMax, y)
+}
+
+// Check if (x, y) is within the canvas
+func (m Miner) onCanvas(x, y int) bool {
+	return x >= 0 && y >= 0 &&
+		x <= int(m.Settings.CanvasSettings.CanvasXMax) && y <= int(m.Settings.CanvasSettings.CanvasYMax)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
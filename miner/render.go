@@ -0,0 +1,49 @@
+/*
+
+This file contains the PNG rendering call for art nodes. The canvas is drawn
+on the miner with the renderer package, so thumbnails and previews don't need
+a browser or the whole chain on the client.
+
+*/
+
+package miner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"../libminer"
+	"../renderer"
+)
+
+// Renders the canvas as it is at RenderRequest.BlockHash (or the tip of the
+// longest chain) and returns it as a PNG.
+func (lmi *LibMinerInterface) RenderPNG(req *libminer.Request, response *libminer.RenderResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var renderReq libminer.RenderRequest
+		json.Unmarshal(req.Msg, &renderReq)
+
+		chain, err := ChainAt(renderReq.BlockHash, -1)
+		if err != nil {
+			return errors.New(CheckStatusCode(err))
+		}
+
+		var buf bytes.Buffer
+		settings := MinerInstance.Settings.CanvasSettings
+		err = renderer.RenderPNG(&buf, renderer.LiveOps(chain),
+			int(settings.CanvasXMax), int(settings.CanvasYMax), renderReq.MaxSize)
+		if err != nil {
+			return err
+		}
+
+		hashes := ChainHashes(chain)
+		response.BlockHash = hashes[len(hashes)-1]
+		response.PNG = buf.Bytes()
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
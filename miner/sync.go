@@ -0,0 +1,346 @@
+/*
+
+This file contains the headers-first chain synchronization between miners.
+
+A miner that joins, or catches up every so often, sends each peer a block
+locator: the hashes of its longest chain, dense near the tip and
+exponentially sparser towards the genesis block. The peer finds the last
+locator hash that is on its own longest chain and answers with the headers
+that follow it, so only what is missing is sent. The headers are checked for
+linkage and proof of work, then the bodies of the unknown blocks are fetched
+in chunks, spread over every peer that announced them, and inserted in chain
+order.
+
+Peer RPC calls:
+  GetHeaders(args GetHeadersArgs, reply *[]BlockHeader)
+  GetBlocks(args GetBlocksArgs, reply *[]blockchain.Block)
+
+*/
+
+package miner
+
+import (
+	"fmt"
+	"sync"
+
+	"../blockchain"
+)
+
+const (
+	// Most headers returned by a single GetHeaders call
+	MAX_HEADERS = 2000
+	// Most blocks returned by a single GetBlocks call
+	MAX_BLOCKS_PER_REQUEST = 64
+	// Number of tip hashes a locator lists one by one before it starts
+	// skipping
+	LOCATOR_DENSE = 10
+)
+
+// What a peer announces about a block before its body is fetched. Hash is
+// checked against the body once it arrives.
+type BlockHeader struct {
+	Hash        string
+	PrevHash    string
+	MinerPubKey string
+	NumOps      int
+}
+
+type GetHeadersArgs struct {
+	// Block locator of the requesting miner, tip first
+	Locator []string
+	// Caps the reply below MAX_HEADERS if > 0
+	Max int
+}
+
+// Held while SyncWithPeers runs
+var syncMutex sync.Mutex
+
+type GetBlocksArgs struct {
+	// At most MAX_BLOCKS_PER_REQUEST hashes
+	Hashes []string
+}
+
+// Returns the headers of the longest chain that follow the last block it
+// shares with args.Locator, oldest first
+func (p *PeerRpc) GetHeaders(args GetHeadersArgs, reply *[]BlockHeader) error {
+	max := MAX_HEADERS
+	if args.Max > 0 && args.Max < max {
+		max = args.Max
+	}
+
+	chain, _ := GetLongestPath(p.miner.Settings.GenesisBlockHash)
+	*reply = headersAfter(args.Locator, chain, max)
+	return nil
+}
+
+// Returns the blocks with the requested hashes, skipping unknown ones
+func (p *PeerRpc) GetBlocks(args GetBlocksArgs, reply *[]blockchain.Block) error {
+	if len(args.Hashes) > MAX_BLOCKS_PER_REQUEST {
+		return fmt.Errorf("GetBlocks: %d hashes requested, at most %d allowed",
+			len(args.Hashes), MAX_BLOCKS_PER_REQUEST)
+	}
+
+	indices := make([]int, 0, len(args.Hashes))
+	for _, hash := range args.Hashes {
+		// Index 0 is the placeholder for the genesis block, which has no body
+		if index, ok := ReadBlockChainMap(hash); ok && index != 0 {
+			indices = append(indices, index)
+		}
+	}
+
+	// InsertBlock may be growing the array
+	BlockArrayMutex.Lock()
+	blocks := make([]blockchain.Block, len(indices))
+	for i, index := range indices {
+		blocks[i] = BlockNodeArray[index].Block
+	}
+	BlockArrayMutex.Unlock()
+
+	*reply = blocks
+	return nil
+}
+
+// Builds the block locator for a chain, given the hash of each of its blocks
+// (see ChainHashes): the last LOCATOR_DENSE hashes, then every 2nd, 4th, ...
+// hash going back, always ending with the genesis block.
+func BlockLocator(hashes []string) []string {
+	locator := make([]string, 0)
+	step := 1
+	for i := len(hashes) - 1; i > 0; i -= step {
+		locator = append(locator, hashes[i])
+		if len(locator) >= LOCATOR_DENSE {
+			step *= 2
+		}
+	}
+	return append(locator, MinerInstance.Settings.GenesisBlockHash)
+}
+
+// Returns up to max headers of chain following the first locator hash that
+// is on chain, or following the genesis block if none is
+func headersAfter(locator []string, chain []blockchain.Block, max int) []BlockHeader {
+	hashes := ChainHashes(chain)
+	heights := make(map[string]int, len(hashes))
+	for i, hash := range hashes {
+		heights[hash] = i
+	}
+
+	start := 1
+	for _, hash := range locator {
+		if height, ok := heights[hash]; ok {
+			start = height + 1
+			break
+		}
+	}
+
+	headers := make([]BlockHeader, 0)
+	for i := start; i < len(chain) && len(headers) < max; i++ {
+		headers = append(headers, BlockHeader{
+			Hash:        hashes[i],
+			PrevHash:    chain[i].PrevHash,
+			MinerPubKey: chain[i].MinerPubKey,
+			NumOps:      len(chain[i].OpHistory)})
+	}
+	return headers
+}
+
+// Catches up with peers: fetches the headers each of them has beyond our
+// longest chain, then the missing bodies from all of them in parallel.
+// Peers that answer get their heartbeat refreshed. Only one sync runs at a
+// time.
+func SyncWithPeers(peers map[string]*Peer) {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	locator := BlockLocator(ChainHashes(chain))
+
+	// Headers of blocks we don't have yet, parents before children, and the
+	// peers that announced each of them
+	missing := make([]BlockHeader, 0)
+	sources := make(map[string][]*Peer)
+
+	for addr, peer := range peers {
+		headers, err := fetchHeaders(addr, peer, locator)
+		if CheckError(err, "SyncWithPeers:"+addr) {
+			continue
+		}
+		peer.Touch()
+
+		for _, header := range headers {
+			if _, known := ReadBlockChainMap(header.Hash); known {
+				continue
+			}
+			if _, seen := sources[header.Hash]; !seen {
+				missing = append(missing, header)
+			}
+			sources[header.Hash] = append(sources[header.Hash], peer)
+		}
+	}
+
+	if len(missing) == 0 {
+		return
+	}
+	Logf(LOG_INFO, "SyncWithPeers:: fetching %d blocks from %d peers", len(missing), len(peers))
+
+	bodies := fetchBlocks(missing, sources)
+	inserted := 0
+	for _, header := range missing {
+		block, ok := bodies[header.Hash]
+		if !ok {
+			continue
+		}
+
+		// The parent may have failed to arrive or to validate
+		if _, ok := ReadBlockChainMap(block.PrevHash); !ok {
+			continue
+		}
+
+		validateLock.Lock()
+		valid := MinerInstance.ValidateBlock(block, GetPath(block.PrevHash))
+		validateLock.Unlock()
+
+		if valid && InsertBlock(block) == nil {
+			inserted++
+		}
+	}
+	Logf(LOG_INFO, "SyncWithPeers:: inserted %d of %d blocks", inserted, len(missing))
+}
+
+// Pages through the headers peer has beyond locator. Headers that don't
+// link up or lack the proof of work end the list and cost the peer points.
+func fetchHeaders(addr string, peer *Peer, locator []string) ([]BlockHeader, error) {
+	headers := make([]BlockHeader, 0)
+	for {
+		var page []BlockHeader
+		err := peer.Call("Peer.GetHeaders", GetHeadersArgs{Locator: locator}, &page, SYNC_CALL_TIMEOUT)
+		if err != nil {
+			return headers, err
+		}
+
+		if len(page) > MAX_HEADERS {
+			Penalize(addr, PENALTY_OVERSIZED, fmt.Sprintf("sent %d headers", len(page)))
+			return headers, nil
+		}
+
+		for _, header := range page {
+			if penalty, reason := checkHeader(header, headers); penalty > 0 {
+				Logln(LOG_INFO, "fetchHeaders:: dropping bad header", header.Hash)
+				Penalize(addr, penalty, reason+" "+header.Hash)
+				return headers, nil
+			}
+			headers = append(headers, header)
+		}
+
+		if len(page) < MAX_HEADERS {
+			return headers, nil
+		}
+
+		// Continue after the last header received
+		locator = append([]string{headers[len(headers)-1].Hash}, locator...)
+	}
+}
+
+// Checks that header follows the previous header, or a block we have, and
+// that its hash meets the difficulty of its kind of block. Returns the
+// penalty for the sender and why, or 0 if the header is fine.
+func checkHeader(header BlockHeader, previous []BlockHeader) (penalty int, reason string) {
+	if len(previous) > 0 {
+		if header.PrevHash != previous[len(previous)-1].Hash {
+			return PENALTY_INVALID_BLOCK, "unlinked header"
+		}
+	} else if _, ok := ReadBlockChainMap(header.PrevHash); !ok {
+		return PENALTY_INVALID_BLOCK, "unlinked header"
+	}
+
+	if !verifyHash(header.Hash, header.NumOps) {
+		return PENALTY_INVALID_POW, "invalid proof of work"
+	}
+	return 0, ""
+}
+
+// Fetches the bodies of headers in chunks of MAX_BLOCKS_PER_REQUEST, spread
+// round robin over the peers that announced each chunk, all peers at once.
+// Chunks a peer fails to deliver are retried with the other peers. Returns
+// the bodies that arrived and match their header, by hash.
+func fetchBlocks(headers []BlockHeader, sources map[string][]*Peer) map[string]blockchain.Block {
+	expected := make(map[string]BlockHeader, len(headers))
+	for _, header := range headers {
+		expected[header.Hash] = header
+	}
+
+	// Every peer gets its own queue of chunks, by index
+	queues := make(map[*Peer][]int)
+	chunks := make([][]string, 0)
+	addChunk := func(chunk []string) {
+		// Peers that announced the last block of a chunk have the rest of it
+		candidates := sources[chunk[len(chunk)-1]]
+		peer := candidates[len(chunks)%len(candidates)]
+		queues[peer] = append(queues[peer], len(chunks))
+		chunks = append(chunks, chunk)
+	}
+
+	// A chunk is cut short where the headers switch to another branch
+	chunk := make([]string, 0, MAX_BLOCKS_PER_REQUEST)
+	for i, header := range headers {
+		if len(chunk) == MAX_BLOCKS_PER_REQUEST || (len(chunk) > 0 && header.PrevHash != headers[i-1].Hash) {
+			addChunk(chunk)
+			chunk = make([]string, 0, MAX_BLOCKS_PER_REQUEST)
+		}
+		chunk = append(chunk, header.Hash)
+	}
+	addChunk(chunk)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	bodies := make(map[string]blockchain.Block)
+	failed := make(map[int]bool)
+
+	// Stores the matching blocks of reply, and reports if the chunk is complete
+	store := func(chunk []string, reply []blockchain.Block) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, block := range reply {
+			hash := GetBlockHash(block)
+			if header, ok := expected[hash]; ok && header.NumOps == len(block.OpHistory) {
+				bodies[hash] = block
+			}
+		}
+		for _, hash := range chunk {
+			if _, ok := bodies[hash]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	for peer, queue := range queues {
+		wg.Add(1)
+		go func(peer *Peer, queue []int) {
+			defer wg.Done()
+			for _, i := range queue {
+				var reply []blockchain.Block
+				err := peer.Call("Peer.GetBlocks", GetBlocksArgs{Hashes: chunks[i]}, &reply, SYNC_CALL_TIMEOUT)
+				if err != nil || !store(chunks[i], reply) {
+					mutex.Lock()
+					failed[i] = true
+					mutex.Unlock()
+				}
+			}
+		}(peer, queue)
+	}
+	wg.Wait()
+
+	// Retry failed chunks one peer at a time
+	for i := range failed {
+		chunk := chunks[i]
+		for _, peer := range sources[chunk[len(chunk)-1]] {
+			var reply []blockchain.Block
+			err := peer.Call("Peer.GetBlocks", GetBlocksArgs{Hashes: chunk}, &reply, SYNC_CALL_TIMEOUT)
+			if err == nil && store(chunk, reply) {
+				break
+			}
+		}
+	}
+
+	return bodies
+}
@@ -0,0 +1,216 @@
+/*
+
+This file contains the encrypted transport of the miner's listeners (see the
+transport package).
+
+Miners talk to each other over TLS with certificates made from their ECDSA
+keys. The key a peer proves it owns has to be the key it registered with the
+server for the address it claims (or the one in the address book if the
+server can't tell, see addrbook.go): the dialing side looks up the key of
+the address it dials before dialing, and the listening side checks the
+address sent in Peer.Connect the same way. Addresses whose key neither knows
+are refused. Announce, Deliver and GetAddrs are only taken for the address
+that connection authenticated as.
+
+Art nodes share the miner's key, so the art node listener takes TLS clients
+that present the miner's own key. Plaintext art nodes, like the ones using the
+original OpenCanvas, are refused unless MinerConfig.AllowArtNodePlaintext is
+set.
+
+*/
+
+package miner
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"../transport"
+)
+
+// Listen addresses of the miners connected to our peer listener that have
+// authenticated, with how many connections each has open
+var inboundPeers = struct {
+	sync.Mutex
+	addrs map[string]int
+}{addrs: make(map[string]int)}
+
+// Contains the address of a miner whose key neither the server nor the
+// address book knows
+type UnknownPeerKeyError string
+
+func (e UnknownPeerKeyError) Error() string {
+	return fmt.Sprintf("No registered key for peer: %s", string(e))
+}
+
+// Returns the public key the miner listening on addr registered with
+func (msi *MinerServerInterface) RegisteredKey(addr string) (*ecdsa.PublicKey, error) {
+	var key ecdsa.PublicKey
+	err := msi.call("RServer.GetKey", addr, &key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Returns a KeyCheck accepting only the key expected for addr. Looks the key
+// up right away, so call it before the handshake rather than from inside it.
+func peerKeyCheck(addr string) (transport.KeyCheck, error) {
+	key := expectedKey(addr)
+	if key == nil {
+		return nil, UnknownPeerKeyError(addr)
+	}
+	return transport.ExpectKey(key), nil
+}
+
+// Opens an authenticated connection to the miner listening on addr. Returns
+// the key it proved it owns.
+func dialPeer(addr string) (*rpc.Client, *ecdsa.PublicKey, error) {
+	check, err := peerKeyCheck(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := transport.Dial(addr, MinerInstance.PrivKey, check)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rpc.NewClient(conn), transport.PeerKey(conn), nil
+}
+
+// Serves the peer RPCs over TLS. Each connection gets its own copy of pRpc,
+// which knows the key the other miner proved it owns.
+func servePeers(ln net.Listener, pRpc *PeerRpc) {
+	for {
+		conn, err := ln.Accept()
+		if CheckError(err, "servePeers:Accept") {
+			return
+		}
+
+		go func(conn net.Conn) {
+			tlsConn, err := transport.Server(conn, MinerInstance.PrivKey, nil)
+			if CheckError(err, "servePeers:Handshake:"+conn.RemoteAddr().String()) {
+				return
+			}
+
+			connRpc := &PeerRpc{miner: pRpc.miner, opCh: pRpc.opCh, blkCh: pRpc.blkCh,
+				opSCh: pRpc.opSCh, blkSCh: pRpc.blkSCh, reqCh: pRpc.reqCh,
+				peerKey: transport.PeerKey(tlsConn)}
+
+			server := rpc.NewServer()
+			server.RegisterName("Peer", connRpc)
+			server.ServeConn(tlsConn)
+
+			connRpc.setPeerAddr("")
+		}(conn)
+	}
+}
+
+// Serves the art node RPCs, over TLS for clients that present the miner's
+// key and in plaintext for the others if that is allowed
+func serveArtNodes(ln net.Listener, server *rpc.Server) {
+	for {
+		conn, err := ln.Accept()
+		if CheckError(err, "serveArtNodes:Accept") {
+			return
+		}
+
+		go func(conn net.Conn) {
+			isTLS, conn, err := transport.IsTLS(conn)
+			if CheckError(err, "serveArtNodes:Peek") {
+				conn.Close()
+				return
+			}
+
+			if !isTLS {
+				if !MinerConfig.AllowArtNodePlaintext {
+					Logf(LOG_INFO, "serveArtNodes:: refusing plaintext art node %s", conn.RemoteAddr())
+					conn.Close()
+					return
+				}
+				server.ServeConn(conn)
+				return
+			}
+
+			tlsConn, err := transport.Server(conn, MinerInstance.PrivKey, transport.ExpectKey(&MinerInstance.PrivKey.PublicKey))
+			if CheckError(err, "serveArtNodes:Handshake:"+conn.RemoteAddr().String()) {
+				return
+			}
+			server.ServeConn(tlsConn)
+		}(conn)
+	}
+}
+
+// Records the address the other side of this connection registered with,
+// after checking it is the one its key was registered for
+func (p *PeerRpc) authenticate(addr net.Addr) error {
+	if addr == nil {
+		return IncompatiblePeerError("no listen address")
+	}
+	check, err := peerKeyCheck(addr.String())
+	if err != nil {
+		return err
+	}
+	if err = check(p.peerKey); err != nil {
+		return err
+	}
+
+	p.setPeerAddr(addr.String())
+	Book.Seen(addr.String(), p.peerKey)
+	return nil
+}
+
+// Records the address this connection authenticated as, "" once it is
+// closed, and keeps inboundPeers up to date
+func (p *PeerRpc) setPeerAddr(addr string) {
+	p.addrLock.Lock()
+	old := p.peerAddr
+	p.peerAddr = addr
+	p.addrLock.Unlock()
+
+	inboundPeers.Lock()
+	defer inboundPeers.Unlock()
+	if old != "" {
+		inboundPeers.addrs[old]--
+		if inboundPeers.addrs[old] <= 0 {
+			delete(inboundPeers.addrs, old)
+		}
+	}
+	if addr != "" {
+		inboundPeers.addrs[addr]++
+	}
+}
+
+// Number of distinct miners we are connected to, whichever side dialed. A
+// peer we dial usually dials us back, and counts once.
+func peerDegree() int {
+	peers := PeerList.Snapshot()
+
+	inboundPeers.Lock()
+	defer inboundPeers.Unlock()
+
+	degree := len(peers)
+	for addr := range inboundPeers.addrs {
+		if _, ok := peers[addr]; !ok {
+			degree++
+		}
+	}
+	return degree
+}
+
+// Checks that from is the address this connection authenticated as
+func (p *PeerRpc) checkFrom(from string) error {
+	p.addrLock.Lock()
+	defer p.addrLock.Unlock()
+
+	if p.peerAddr == "" {
+		return fmt.Errorf("%s has not called Connect", from)
+	}
+	if p.peerAddr != from {
+		return fmt.Errorf("connection of %s claims to be %s", p.peerAddr, from)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+
+This file contains the dry run of Draw for art nodes: a shape is checked
+against the tip of the longest chain exactly as a miner would check it,
+without being signed or propagated.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"../blockchain"
+	"../libminer"
+	"../shapelib"
+	"../utils"
+)
+
+// Checks a shape the way Draw would and reports its cost and the shapes it
+// conflicts with. Rejections are reported in the response, not as an error.
+func (lmi *LibMinerInterface) ValidateShape(req *libminer.Request, response *libminer.ValidateShapeResponse) (err error) {
+	if Verify(req.Msg, req.HashedMsg, req.R, req.S, MinerInstance.PrivKey) {
+		var validateReq libminer.ValidateShapeRequest
+		json.Unmarshal(req.Msg, &validateReq)
+
+		op := blockchain.Operation{
+			OpType:    blockchain.ADD,
+			SVGString: validateReq.SVGString,
+			Fill:      validateReq.Fill,
+			Stroke:    validateReq.Stroke}
+
+		*response = ValidateShape(op, utils.GetPublicKeyString(MinerInstance.PrivKey.PublicKey))
+		return nil
+	}
+
+	err = fmt.Errorf("invalid user")
+	return err
+}
+
+// Checks op, as if pubKey had submitted it, against the longest chain
+func ValidateShape(op blockchain.Operation, pubKey string) (result libminer.ValidateShapeResponse) {
+	result.Conflicts = make([]string, 0)
+
+	shapes, err := MinerInstance.getShapesFromOp(op)
+	if err != nil {
+		result.Error = CheckStatusCode(err)
+		return result
+	}
+
+	subarrs := make([]shapelib.PixelSubArray, len(shapes))
+	for i, shape := range shapes {
+		subarr, cost := shape.SubArrayAndCost()
+		subarrs[i] = subarr
+		result.InkCost += uint32(cost)
+	}
+
+	validateLock.Lock()
+	chain, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
+	opinfo := blockchain.OperationInfo{PubKey: pubKey, Op: op}
+	err = MinerInstance.checkAdd(opinfo, chain)
+	validateLock.Unlock()
+
+	if err != nil {
+		result.Error = CheckStatusCode(err)
+	}
+	result.InkRemaining = uint32(CalculateInk(pubKey))
+
+	index := ShapeIndexAt(chain)
+	result.BlockHash = index.BlockHash
+	result.Conflicts, result.Overlap, result.OverlapPixels = index.Overlaps(subarrs, pubKey)
+	return result
+}
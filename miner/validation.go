@@ -8,7 +8,12 @@ and delete operations for shapes in the blockchain.
 package miner
 
 import (
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math/big"
 
 	"../blockchain"
 	"../libminer"
@@ -20,7 +25,16 @@ func (e DuplicateError) Error() string {
 	return fmt.Sprintf("Duplicate shapehash: %s", string(e))
 }
 
-const LOG_VALIDATION = true
+// Contains why an op is invalid on any chain: a bad signature or shapes that
+// don't parse
+type InvalidOpError string
+
+func (e InvalidOpError) Error() string {
+	return fmt.Sprintf("Invalid op: %s", string(e))
+}
+
+// Set from the configured log level, see Config.applyLogLevel
+var LOG_VALIDATION = true
 
 func (m Miner) ValidateBlock(block blockchain.Block, chain []blockchain.Block) bool {
 	//fmt.Println("ValidateBlock::TODO: Unfinished")
@@ -39,7 +53,7 @@ func (m Miner) ValidateBlock(block blockchain.Block, chain []blockchain.Block) b
 
 // Validates a set of operations against the longest block chain
 func ValidateOps(ops []blockchain.OperationInfo, chain []blockchain.Block) []blockchain.OperationInfo {
-	fmt.Println("ValidateOps")
+	Logln(LOG_DEBUG, "ValidateOps")
 	//chain, _ = GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
 	testblock := new(blockchain.Block)
 	testblock.MinerPubKey = "TESTTESTTESTTESTTESTTESTTESTTESTTEST"
@@ -48,60 +62,164 @@ func ValidateOps(ops []blockchain.OperationInfo, chain []blockchain.Block) []blo
 		oldchain := make([]blockchain.Block, 0)
 		oldchain = append(oldchain, chain...)
 		testchain := append(oldchain, *testblock)
-		op := opinfo.Op
-		shape, err := MinerInstance.getShapeFromOp(op)
-		if err != nil {
-			continue
-		}
-
-		subarr, inkRequired := shape.SubArrayAndCost()
-		if opinfo.Op.OpType == blockchain.ADD {
-			err = MinerInstance.checkInkAndConflicts(subarr, inkRequired, opinfo.PubKey, testchain, op.SVGString, opinfo.OpSig)
-		} else {
-			err = MinerInstance.checkDeletion(opinfo.AddSig, opinfo.PubKey, testchain)
-		}
-		if err != nil {
+		if err := validateOpInfo(opinfo, testchain); err != nil {
 			continue
 		}
 
 		testblock.OpHistory = append(testblock.OpHistory, opinfo)
 	}
-	fmt.Println("ValidateOps done")
+	Logln(LOG_DEBUG, "ValidateOps done")
 	//chain, _ = GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
 	return testblock.OpHistory
 }
 
-// Checks if there are overlaps and enough ink
-func ValidateOperation(op blockchain.Operation, pubKey string, opSig string) error {
-	shape, err := MinerInstance.getShapeFromOp(op)
+// Checks a single add, batch or delete operation against chain. Returns the
+// validation error, which is a DuplicateError if it is already in chain.
+func validateOpInfo(opinfo blockchain.OperationInfo, chain []blockchain.Block) error {
+	switch opinfo.Op.OpType {
+	case blockchain.DELETE:
+		if err := checkDeletedCopy(opinfo, chain); err != nil {
+			return err
+		}
+		return MinerInstance.checkDeletion(opinfo.AddSig, opinfo.PubKey, chain)
+	case blockchain.UPDATE:
+		return MinerInstance.checkUpdate(opinfo, chain)
+	default:
+		return MinerInstance.checkAdd(opinfo, chain)
+	}
+}
+
+// Checks what doesn't depend on the chain: that OpSig is the signature of Op
+// by PubKey, as made by signOp, and that the shapes of Op parse. Returns an
+// InvalidOpError.
+func checkOpStateless(opinfo blockchain.OperationInfo) error {
+	pubKey, err := parsePublicKey(opinfo.PubKey)
 	if err != nil {
-		return err
+		return InvalidOpError("public key: " + err.Error())
 	}
 
-	subarr, inkRequired := shape.SubArrayAndCost()
+	sigBytes, err := hex.DecodeString(opinfo.OpSig)
+	if err != nil {
+		return InvalidOpError("signature: " + err.Error())
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err = asn1.Unmarshal(sigBytes, &sig); err != nil {
+		return InvalidOpError("signature: " + err.Error())
+	}
+
+	opBytes, _ := json.Marshal(opinfo.Op)
+	if !ecdsa.Verify(pubKey, opBytes, sig.R, sig.S) {
+		return InvalidOpError("signature does not match " + opinfo.OpSig)
+	}
+
+	// A DELETE only carries a copy of the deleted op, checked against the
+	// chain in validateOpInfo
+	if opinfo.Op.OpType == blockchain.DELETE {
+		return nil
+	}
+	if _, err = MinerInstance.getShapesFromOp(opinfo.Op); err != nil {
+		return InvalidOpError(err.Error())
+	}
+	return nil
+}
+
+// Checks that a DELETE carries the SVGString, Fill and Stroke of the op it
+// deletes, as newDeleteOp copies them, so blockchain.DeletedOp can tell what
+// it removed. A DELETE of an op that isn't in chain is left to checkDeletion.
+func checkDeletedCopy(opinfo blockchain.OperationInfo, chain []blockchain.Block) error {
+	deleted, ok := blockchain.FindOperation(chain, opinfo.AddSig)
+	if !ok {
+		return nil
+	}
+	if deleted.Op.SVGString != opinfo.Op.SVGString || deleted.Op.Fill != opinfo.Op.Fill ||
+		deleted.Op.Stroke != opinfo.Op.Stroke {
+		return libminer.InvalidShapeSvgStringError(opinfo.Op.SVGString)
+	}
+	return nil
+}
 
+// Checks if there are overlaps and enough ink
+func ValidateOperation(op blockchain.Operation, pubKey string, opSig string) error {
 	validateLock.Lock()
 	defer validateLock.Unlock()
 
 	blocks, _ := GetLongestPath(MinerInstance.Settings.GenesisBlockHash)
-	err = MinerInstance.checkInkAndConflicts(subarr, inkRequired, pubKey, blocks, op.SVGString, opSig)
+	opinfo := blockchain.OperationInfo{OpSig: opSig, PubKey: pubKey, Op: op}
+	return MinerInstance.checkAdd(opinfo, blocks)
+}
+
+// Checks an ADD or a BATCH. The shapes of a batch must not overlap each other
+// and are checked against blocks together, so either all of them are valid or
+// the whole batch is rejected.
+func (m Miner) checkAdd(opinfo blockchain.OperationInfo, blocks []blockchain.Block) error {
+	return m.checkReplace(opinfo, 0, blocks)
+}
+
+// Checks an UPDATE: the replaced shape (AddSig) must be a live shape of the
+// same owner, and the new shape is checked like an ADD, charging only the
+// difference in ink. The replaced shape can't conflict since only shapes of
+// other owners are checked for overlaps.
+func (m Miner) checkUpdate(opinfo blockchain.OperationInfo, blocks []blockchain.Block) error {
+	replaced, ok := blockchain.FindOperation(blocks, opinfo.AddSig)
+	if !ok || replaced.PubKey != opinfo.PubKey || replaced.Op.OpType == blockchain.DELETE {
+		return libminer.ShapeOwnerError(opinfo.AddSig)
+	}
 
+	refund, err := m.opCost(replaced.Op)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if err := m.checkReplace(opinfo, refund, blocks); err != nil {
+		return err
+	}
+	return m.checkDeletion(opinfo.AddSig, opinfo.PubKey, blocks)
+}
+
+// Checks the shapes of opinfo for bounds, overlaps and ink, with refund ink
+// given back first
+func (m Miner) checkReplace(opinfo blockchain.OperationInfo, refund int, blocks []blockchain.Block) error {
+	shapes, err := m.getShapesFromOp(opinfo.Op)
+	if err != nil {
+		return err
+	}
+
+	var batcharr shapelib.PixelArray
+	if len(shapes) > 1 {
+		batcharr = shapelib.NewPixelArray(int(m.Settings.CanvasSettings.CanvasXMax),
+			int(m.Settings.CanvasSettings.CanvasYMax))
+	}
+
+	subarrs := make([]shapelib.PixelSubArray, len(shapes))
+	inkRequired := 0
+	for i, shape := range shapes {
+		subarr, cost := shape.SubArrayAndCost()
+		if batcharr != nil {
+			if batcharr.HasConflict(subarr) {
+				Logln(LOG_DEBUG, "checkAdd: shapes in batch overlap")
+				return libminer.ShapeOverlapError(opinfo.Op.SVGString)
+			}
+			batcharr.MergeSubArray(subarr)
+		}
+
+		subarrs[i] = subarr
+		inkRequired += cost
+	}
+
+	return m.checkInkAndConflicts(subarrs, inkRequired-refund, opinfo.PubKey, blocks, opinfo.Op.SVGString, opinfo.OpSig)
 }
 
 // Function used to determine if an add operation is allowed on the blockchain.
-func (m Miner) checkInkAndConflicts(subarr shapelib.PixelSubArray, inkRequired int,
+func (m Miner) checkInkAndConflicts(subarrs []shapelib.PixelSubArray, inkRequired int,
 	pubkey string, blocks []blockchain.Block, svgString string, opSig string) error {
 	if LOG_VALIDATION {
-		fmt.Println("checkInkAndConflicts called")
+		Logln(LOG_DEBUG, "checkInkAndConflicts called")
 	}
 
 	pubkeyInk := uint32(0)
 	shapesExisting := make(map[string]*blockchain.OperationInfo)
+	// Cost of the shapes of pubkey, for the refund of an UPDATE or DELETE
+	shapeCosts := make(map[string]int)
 
 	// Iterate over all blocks in this structure to form the pixel array
 	// formed by all shapes not from this pubkey, and the ink remaining
@@ -130,36 +248,45 @@ func (m Miner) checkInkAndConflicts(subarr shapelib.PixelSubArray, inkRequired i
 					return DuplicateError("opSig")
 				}
 
-				shape, err := m.getShapeFromOp(op)
-				if err != nil {
-					fmt.Println("CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
+				// A DELETE refunds what the deleted shape cost,
+				// not what its copy of the shape would
+				if op.OpType == blockchain.DELETE {
+					pubkeyInk += uint32(shapeCosts[opInfo.AddSig])
 					continue
 				}
 
-				_, cost := shape.SubArrayAndCost()
+				cost, err := m.opCost(op)
+				if err != nil {
+					Logln(LOG_ERROR, "CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
+					continue
+				}
 
 				// Don't fill in the pixels for the same pubkey,
 				// but compute the ink required in order to
 				// check if pubkey has sufficient ink.
-				// Don't bother validating that a DELETE has a
-				// corresponding ADD. Assume all are valid.
-				if op.OpType == blockchain.ADD {
+				switch op.OpType {
+				case blockchain.UPDATE:
+					pubkeyInk += uint32(shapeCosts[opInfo.AddSig])
+					pubkeyInk -= uint32(cost)
+					shapeCosts[opInfo.OpSig] = cost
+				default:
 					pubkeyInk -= uint32(cost)
-				} else {
-					pubkeyInk += uint32(cost)
+					shapeCosts[opInfo.OpSig] = cost
 				}
 			} else {
-				if op.OpType == blockchain.ADD {
+				// An UPDATE removes the replaced shape and adds the new one
+				if op.OpType == blockchain.DELETE || op.OpType == blockchain.UPDATE {
+					delete(shapesExisting, opInfo.AddSig)
+				}
+				if op.OpType != blockchain.DELETE {
 					shapesExisting[opInfo.OpSig] = &opInfo
-				} else {
-					delete(shapesExisting, opInfo.OpSig)
 				}
 			}
 		}
 	}
 
 	if inkRequired > int(pubkeyInk) {
-		fmt.Println("checkInkAndConflicts: insufficient ink:", inkRequired, " needed vs ", pubkeyInk)
+		Logln(LOG_DEBUG, "checkInkAndConflicts: insufficient ink:", inkRequired, " needed vs ", pubkeyInk)
 		return libminer.InsufficientInkError(uint32(inkRequired))
 	}
 
@@ -168,18 +295,22 @@ func (m Miner) checkInkAndConflicts(subarr shapelib.PixelSubArray, inkRequired i
 
 	// Merge all shapes existing into the pixel array for validating conflicts
 	for _, v := range shapesExisting {
-		shape, err := m.getShapeFromOp(v.Op)
+		shapes, err := m.getShapesFromOp(v.Op)
 		if err != nil {
-			fmt.Println("CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
+			Logln(LOG_ERROR, "CRITICAL ERROR: BAD SHAPE IN BLOCKCHAIN")
 		}
 
-		subarr, _ := shape.SubArrayAndCost()
-		pixelarr.MergeSubArray(subarr)
+		for _, shape := range shapes {
+			subarr, _ := shape.SubArrayAndCost()
+			pixelarr.MergeSubArray(subarr)
+		}
 	}
 
-	if pixelarr.HasConflict(subarr) {
-		fmt.Println("checkInkAndConflicts: conflict found")
-		return libminer.ShapeOverlapError(svgString)
+	for _, subarr := range subarrs {
+		if pixelarr.HasConflict(subarr) {
+			Logln(LOG_DEBUG, "checkInkAndConflicts: conflict found")
+			return libminer.ShapeOverlapError(svgString)
+		}
 	}
 
 	return nil
@@ -188,7 +319,7 @@ func (m Miner) checkInkAndConflicts(subarr shapelib.PixelSubArray, inkRequired i
 // Function used to determine if a delete operation is allowed on the blockchain.
 func (m Miner) checkDeletion(sHash string, pubkey string, blocks []blockchain.Block) error {
 	if LOG_VALIDATION {
-		fmt.Println("checkDeletion called")
+		Logln(LOG_DEBUG, "checkDeletion called")
 	}
 
 	delAllowed := false
@@ -205,10 +336,10 @@ func (m Miner) checkDeletion(sHash string, pubkey string, blocks []blockchain.Bl
 
 			if opInfo.PubKey == pubkey {
 				if opInfo.OpSig == sHash {
-					fmt.Println("Shape exists, cool")
+					Logln(LOG_DEBUG, "Shape exists, cool")
 					delAllowed = true
 				} else if opInfo.AddSig == sHash {
-					fmt.Println("Deleted already?! Oh no!")
+					Logln(LOG_DEBUG, "Deleted already?! Oh no!")
 					delAllowed = false
 					goto breakOuterLoop
 				}
@@ -47,3 +47,9 @@ type MinerInfo struct {
 	Key     ecdsa.PublicKey
 }
 
+// Number of distinct peers a miner is connected to, whichever side dialed
+type DegreeReport struct {
+	Key    ecdsa.PublicKey
+	Degree int
+}
+
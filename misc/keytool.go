@@ -0,0 +1,156 @@
+/*
+
+Command line tool for managing the key store used by the miner and art apps.
+
+Usage:
+
+$ go run misc/keytool.go [-d dir] [-p passphrase] <command> [args]
+  -d string
+    	Key store directory (default "./keys")
+  -p string
+    	Passphrase. Read from $INKCOIN_PASSPHRASE or stdin when not given.
+
+When stdin is a terminal, echo is turned off with stty while the passphrase
+is typed. Otherwise the first line of stdin is the passphrase, so it can be
+piped in:
+
+$ cat passphrase.txt | go run misc/keytool.go generate alice
+  -hex
+    	For export: print the hex key pair (key-pairs.txt format) instead of PEM
+
+Commands:
+  generate <name>            Generate a new key pair and store it as <name>
+  list                       List stored keys and their public keys
+  import <name> <key|file>   Store an existing key. Accepts a hex private key,
+                             a key-pairs.txt file or a PEM file
+  export <name>              Print the decrypted private key
+  pubkey <name>              Print the hex public key of <name>
+
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"../keystore"
+)
+
+func main() {
+	dir := flag.String("d", keystore.DEFAULT_DIR, "Key store directory")
+	passphrase := flag.String("p", "", "Passphrase. Read from $"+keystore.PASSPHRASE_ENV+" or stdin when not given.")
+	asHex := flag.Bool("hex", false, "For export: print the hex key pair (key-pairs.txt format) instead of PEM")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+	}
+
+	ks := keystore.New(*dir)
+
+	switch args[0] {
+	case "generate":
+		requireArgs(args, 2)
+		privKey, err := ks.Generate(args[1], readPassphrase(*passphrase))
+		checkError(err)
+		fmt.Println("Generated key", args[1])
+		fmt.Println("Public key:", keystore.PublicKeyString(privKey.PublicKey))
+	case "list":
+		infos, err := ks.List()
+		checkError(err)
+		for _, info := range infos {
+			fmt.Printf("%s\t%s\t%s\n", info.Name, info.Created.Format("2006-01-02 15:04:05"), info.PublicKey)
+		}
+	case "import":
+		requireArgs(args, 3)
+		privKey, err := parseKeyArg(args[2])
+		checkError(err)
+		err = ks.Import(args[1], readPassphrase(*passphrase), privKey)
+		checkError(err)
+		fmt.Println("Imported key", args[1])
+		fmt.Println("Public key:", keystore.PublicKeyString(privKey.PublicKey))
+	case "export":
+		requireArgs(args, 2)
+		pass := readPassphrase(*passphrase)
+		if *asHex {
+			privKey, err := ks.Load(args[1], pass)
+			checkError(err)
+			der, err := x509.MarshalECPrivateKey(privKey)
+			checkError(err)
+			fmt.Println(hex.EncodeToString(der))
+			fmt.Println(keystore.PublicKeyString(privKey.PublicKey))
+		} else {
+			pemBytes, err := ks.Export(args[1], pass)
+			checkError(err)
+			os.Stdout.Write(pemBytes)
+		}
+	case "pubkey":
+		requireArgs(args, 2)
+		infos, err := ks.List()
+		checkError(err)
+		for _, info := range infos {
+			if info.Name == args[1] {
+				fmt.Println(info.PublicKey)
+				return
+			}
+		}
+		checkError(keystore.KeyNotFoundError(args[1]))
+	default:
+		usage()
+	}
+}
+
+// Accepts a hex private key, a key-pairs.txt file (private key on the first
+// line) or a PEM file
+func parseKeyArg(arg string) (*ecdsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(arg)
+	if err != nil {
+		// Not a file, treat it as a hex key
+		return keystore.ParseHexKey(arg)
+	}
+
+	if strings.Contains(string(data), "-----BEGIN") {
+		return keystore.ParsePEMKey(data)
+	}
+	return keystore.ParseHexKey(strings.Split(string(data), "\n")[0])
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: go run misc/keytool.go [-d dir] [-p passphrase] [-hex] <generate|list|import|export|pubkey> [args]")
+	flag.PrintDefaults()
+	os.Exit(1)
+}
+
+func requireArgs(args []string, n int) {
+	if len(args) < n {
+		usage()
+	}
+}
+
+// Use the passphrase given on the command line, otherwise the one in
+// keystore.PASSPHRASE_ENV or stdin
+func readPassphrase(passphrase string) string {
+	if passphrase != "" {
+		return passphrase
+	}
+
+	passphrase, err := keystore.ReadPassphrase()
+	checkError(err)
+	return passphrase
+}
+
+// If error is non-nil, print it out and exit.
+func checkError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error ", err.Error())
+		os.Exit(1)
+	}
+}
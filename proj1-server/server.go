@@ -3,9 +3,29 @@
 Implements an example server for the BlockArt project, to be used in
 project 1 of UBC CS 416 2017W2.
 
-This server takes in settings from an input json files and implements
-a simple strategy for GetNodes: return a fixed number of random miners
-("num-miner-to-return" in the json config file).
+This server takes in settings from an input json files and returns a
+fixed number of miners from GetNodes ("num-miner-to-return" in the json
+config file). Which miners are returned is decided by the strategy named
+by "get-nodes-strategy":
+
+  deterministic    (default) a shuffle seeded by the caller's key, so the
+                   same miner always gets the same answer
+  random           a fresh random sample on every call
+  ring             the miners that follow the caller, ordered by address
+  small-world      half ring neighbours on both sides, half random links
+  locality         miners in the caller's subnet first (a stand-in for
+                   being close by), then random ones
+  least-connected  the miners with the fewest connections
+
+Miners report how many peers they are connected to with ReportDegree. Each
+miner handed out by GetNodes counts one connection more until its next
+report, so least-connected doesn't send everyone to the same miner.
+
+If "registry-path" is set, registrations are saved to that file and loaded
+again when the server starts. Restored miners get "restart-grace-period"
+milliseconds (default: 5 heartbeats) to send a heartbeat before they are
+dropped, so they carry on as if the server had never gone away. A miner
+that registers again with the same key and address is taken back as well.
 
 Usage:
 
@@ -21,6 +41,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -34,6 +55,8 @@ import (
 	"sort"
 	"sync"
 	"time"
+
+	"../minerserver"
 )
 
 // Errors that the server could return.
@@ -45,6 +68,12 @@ func (e KeyAlreadyRegisteredError) Error() string {
 	return fmt.Sprintf("BlockArt server: key already registered [%s]", string(e))
 }
 
+type UnknownAddressError string
+
+func (e UnknownAddressError) Error() string {
+	return fmt.Sprintf("BlockArt server: unknown address [%s]", string(e))
+}
+
 type AddressAlreadyRegisteredError string
 
 func (e AddressAlreadyRegisteredError) Error() string {
@@ -106,13 +135,60 @@ type RServer int
 
 type Miner struct {
 	Address         net.Addr
+	Key             ecdsa.PublicKey
 	RecentHeartbeat int64
+	// Peers the miner last reported, plus the times it was handed out since
+	Degree int
+	// Restored miners aren't dropped before this time, in UnixNano
+	GraceUntil int64
 }
 
 type Config struct {
 	MinerSettings    MinerNetSettings `json:"miner-settings"`
 	RpcIpPort        string           `json:"rpc-ip-port"`
 	NumMinerToReturn uint8            `json:"num-miner-to-return"`
+	GetNodesStrategy string           `json:"get-nodes-strategy"`
+	// File the registrations are saved to. Empty keeps them in memory only.
+	RegistryPath string `json:"registry-path"`
+	// Milliseconds restored miners have to send a heartbeat
+	RestartGracePeriod uint32 `json:"restart-grace-period"`
+}
+
+// A registration as saved in the registry file
+type RegistryEntry struct {
+	Address string `json:"address"`
+	// Curve name and elliptic.Marshal of the public key, in hex
+	Curve string `json:"curve"`
+	Key   string `json:"key"`
+}
+
+// Strategies for GetNodes
+const (
+	STRATEGY_DETERMINISTIC   = "deterministic"
+	STRATEGY_RANDOM          = "random"
+	STRATEGY_RING            = "ring"
+	STRATEGY_SMALL_WORLD     = "small-world"
+	STRATEGY_LOCALITY        = "locality"
+	STRATEGY_LEAST_CONNECTED = "least-connected"
+)
+
+// Prefix length of the subnets the locality strategy groups miners by
+const (
+	LOCALITY_PREFIX_V4 = 24
+	LOCALITY_PREFIX_V6 = 48
+)
+
+// Picks up to n miners out of candidates for self. candidates doesn't hold
+// self and is sorted by address.
+type NodeSelector func(self *Miner, candidates []*Miner, n int) []*Miner
+
+var strategies = map[string]NodeSelector{
+	STRATEGY_DETERMINISTIC:   selectDeterministic,
+	STRATEGY_RANDOM:          selectRandom,
+	STRATEGY_RING:            selectRing,
+	STRATEGY_SMALL_WORLD:     selectSmallWorld,
+	STRATEGY_LOCALITY:        selectLocality,
+	STRATEGY_LEAST_CONNECTED: selectLeastConnected,
 }
 
 type AllMiners struct {
@@ -138,6 +214,16 @@ func readConfigOrDie(path string) {
 
 	err = json.Unmarshal(buffer, &config)
 	handleErrorFatal("parse config", err)
+
+	if config.GetNodesStrategy == "" {
+		config.GetNodesStrategy = STRATEGY_DETERMINISTIC
+	}
+	if _, ok := strategies[config.GetNodesStrategy]; !ok {
+		handleErrorFatal("config", fmt.Errorf("unknown get-nodes-strategy %s", config.GetNodesStrategy))
+	}
+	if config.RestartGracePeriod == 0 {
+		config.RestartGracePeriod = 5 * config.MinerSettings.HeartBeat
+	}
 }
 
 // Parses args, setups up RPC server.
@@ -157,6 +243,10 @@ func main() {
 
 	rand.Seed(time.Now().UnixNano())
 
+	err := loadRegistry()
+	handleErrorFatal("load registry", err)
+	go monitor(time.Duration(config.MinerSettings.HeartBeat) * time.Millisecond)
+
 	rserver := new(RServer)
 
 	server := rpc.NewServer()
@@ -178,22 +268,130 @@ type MinerInfo struct {
 	Key     ecdsa.PublicKey
 }
 
-// Function to delete dead miners (no recent heartbeat)
-func monitor(k string, heartBeatInterval time.Duration) {
+// Function to delete dead miners (no recent heartbeat). One goroutine
+// checks every miner a few times per heartbeat interval.
+func monitor(heartBeatInterval time.Duration) {
 	for {
+		time.Sleep(heartBeatInterval / 4)
+
 		allMiners.Lock()
-		if time.Now().UnixNano()-allMiners.all[k].RecentHeartbeat > int64(heartBeatInterval) {
-			outLog.Printf("%s timed out\n", allMiners.all[k].Address.String())
-			delete(allMiners.all, k)
-			allMiners.Unlock()
-			return
+		now := time.Now().UnixNano()
+		removed := false
+		for k, miner := range allMiners.all {
+			if now-miner.RecentHeartbeat > int64(heartBeatInterval) && now > miner.GraceUntil {
+				outLog.Printf("%s timed out\n", miner.Address.String())
+				delete(allMiners.all, k)
+				removed = true
+			}
+		}
+		if removed {
+			saveRegistry()
 		}
-		outLog.Printf("%s is alive\n", allMiners.all[k].Address.String())
 		allMiners.Unlock()
-		time.Sleep(heartBeatInterval)
 	}
 }
 
+// Restores the registrations saved in config.RegistryPath, each with the
+// restart grace period to send a heartbeat
+func loadRegistry() error {
+	if config.RegistryPath == "" {
+		return nil
+	}
+
+	buffer, err := ioutil.ReadFile(config.RegistryPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []RegistryEntry
+	if err = json.Unmarshal(buffer, &entries); err != nil {
+		return err
+	}
+
+	allMiners.Lock()
+	defer allMiners.Unlock()
+
+	now := time.Now()
+	graceUntil := now.Add(time.Duration(config.RestartGracePeriod) * time.Millisecond).UnixNano()
+	for _, entry := range entries {
+		addr, err := net.ResolveTCPAddr("tcp", entry.Address)
+		if err != nil {
+			errLog.Printf("skipping registry entry %s: %s\n", entry.Address, err)
+			continue
+		}
+		key, err := parseRegistryKey(entry.Curve, entry.Key)
+		if err != nil {
+			errLog.Printf("skipping registry entry %s: %s\n", entry.Address, err)
+			continue
+		}
+
+		allMiners.all[pubKeyToString(key)] = &Miner{addr, key, now.UnixNano(), 0, graceUntil}
+	}
+
+	outLog.Printf("Restored %d miners from %s\n", len(allMiners.all), config.RegistryPath)
+	return nil
+}
+
+// Writes every registration to config.RegistryPath. Must be called with
+// allMiners locked.
+func saveRegistry() {
+	if config.RegistryPath == "" {
+		return
+	}
+
+	entries := make([]RegistryEntry, 0, len(allMiners.all))
+	for _, miner := range allMiners.all {
+		entries = append(entries, RegistryEntry{
+			Address: miner.Address.String(),
+			Curve:   miner.Key.Curve.Params().Name,
+			Key:     hex.EncodeToString(elliptic.Marshal(miner.Key.Curve, miner.Key.X, miner.Key.Y))})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Address < entries[j].Address })
+
+	bytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		errLog.Printf("save registry: %s\n", err)
+		return
+	}
+
+	// Write to a temporary file first so a crash can't leave half a registry
+	tmp := config.RegistryPath + ".tmp"
+	if err = ioutil.WriteFile(tmp, bytes, 0644); err == nil {
+		err = os.Rename(tmp, config.RegistryPath)
+	}
+	if err != nil {
+		errLog.Printf("save registry: %s\n", err)
+	}
+}
+
+func parseRegistryKey(curveName, key string) (ecdsa.PublicKey, error) {
+	curves := map[string]elliptic.Curve{
+		"P-224": elliptic.P224(),
+		"P-256": elliptic.P256(),
+		"P-384": elliptic.P384(),
+		"P-521": elliptic.P521(),
+	}
+	curve, ok := curves[curveName]
+	if !ok {
+		return ecdsa.PublicKey{}, fmt.Errorf("unknown curve %s", curveName)
+	}
+
+	bytes, err := hex.DecodeString(key)
+	if err != nil {
+		return ecdsa.PublicKey{}, err
+	}
+	x, y := elliptic.Unmarshal(curve, bytes)
+	if x == nil {
+		return ecdsa.PublicKey{}, fmt.Errorf("invalid key")
+	}
+
+	// Keys are sent as *elliptic.CurveParams, the only curve registered with gob
+	return ecdsa.PublicKey{Curve: curve.Params(), X: x, Y: y}, nil
+}
+
 func pubKeyToString(key ecdsa.PublicKey) string {
 	return string(elliptic.Marshal(key.Curve, key.X, key.Y))
 }
@@ -212,7 +410,15 @@ func (s *RServer) Register(m MinerInfo, r *MinerNetSettings) error {
 
 	k := pubKeyToString(m.Key)
 	if miner, exists := allMiners.all[k]; exists {
-		return KeyAlreadyRegisteredError(miner.Address.String())
+		if miner.Address.String() != m.Address.String() {
+			return KeyAlreadyRegisteredError(miner.Address.String())
+		}
+
+		// The same miner registering again, e.g. after a server restart
+		miner.RecentHeartbeat = time.Now().UnixNano()
+		*r = config.MinerSettings
+		outLog.Printf("Got Register again from %s\n", m.Address.String())
+		return nil
 	}
 
 	for _, miner := range allMiners.all {
@@ -223,10 +429,12 @@ func (s *RServer) Register(m MinerInfo, r *MinerNetSettings) error {
 
 	allMiners.all[k] = &Miner{
 		m.Address,
+		m.Key,
 		time.Now().UnixNano(),
+		0,
+		0,
 	}
-
-	go monitor(k, time.Duration(config.MinerSettings.HeartBeat)*time.Millisecond)
+	saveRegistry()
 
 	*r = config.MinerSettings
 
@@ -235,13 +443,14 @@ func (s *RServer) Register(m MinerInfo, r *MinerNetSettings) error {
 	return nil
 }
 
-type Addresses []net.Addr
+type MinersByAddress []*Miner
 
-func (a Addresses) Len() int           { return len(a) }
-func (a Addresses) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a Addresses) Less(i, j int) bool { return a[i].String() < a[j].String() }
+func (m MinersByAddress) Len() int           { return len(m) }
+func (m MinersByAddress) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m MinersByAddress) Less(i, j int) bool { return m[i].Address.String() < m[j].Address.String() }
 
-// Returns addresses for a subset of miners in the system.
+// Returns addresses for a subset of miners in the system, picked by the
+// configured strategy.
 //
 // Returns:
 // - UnknownKeyError if the server does not know a miner with this publicKey.
@@ -250,42 +459,184 @@ func (s *RServer) GetNodes(key ecdsa.PublicKey, addrSet *[]net.Addr) error {
 	// TODO: validate miner's GetNodes protocol? (could monitor state
 	// of network graph/connectivity and validate protocol FSM)
 
-	allMiners.RLock()
-	defer allMiners.RUnlock()
+	// Handing out a miner bumps its degree
+	allMiners.Lock()
+	defer allMiners.Unlock()
 
 	k := pubKeyToString(key)
 
-	if _, ok := allMiners.all[k]; !ok {
+	self, ok := allMiners.all[k]
+	if !ok {
 		return unknownKeyError
 	}
 
-	minerAddresses := make([]net.Addr, 0, len(allMiners.all)-1)
-
+	candidates := make([]*Miner, 0, len(allMiners.all)-1)
 	for pubKey, miner := range allMiners.all {
 		if pubKey == k {
 			continue
 		}
-		minerAddresses = append(minerAddresses, miner.Address)
+		candidates = append(candidates, miner)
 	}
+	sort.Sort(MinersByAddress(candidates))
 
-	sort.Sort(Addresses(minerAddresses))
+	n := len(candidates)
+	if int(config.NumMinerToReturn) < n {
+		n = int(config.NumMinerToReturn)
+	}
 
-	deterministicRandomNumber := key.X.Int64() % 32
-	r := rand.New(rand.NewSource(deterministicRandomNumber))
-	for n := len(minerAddresses); n > 0; n-- {
-		randIndex := r.Intn(n)
-		minerAddresses[n-1], minerAddresses[randIndex] = minerAddresses[randIndex], minerAddresses[n-1]
+	selected := strategies[config.GetNodesStrategy](self, candidates, n)
+	minerAddresses := make([]net.Addr, len(selected))
+	for i, miner := range selected {
+		minerAddresses[i] = miner.Address
+		miner.Degree++
 	}
+	*addrSet = minerAddresses
 
-	n := len(minerAddresses)
-	if int(config.NumMinerToReturn) < n {
-		n = int(config.NumMinerToReturn)
+	return nil
+}
+
+// Records how many peers a miner is connected to, for least-connected.
+//
+// Returns:
+// - UnknownKeyError if the server does not know a miner with this publicKey.
+func (s *RServer) ReportDegree(report minerserver.DegreeReport, _ignored *bool) error {
+	allMiners.Lock()
+	defer allMiners.Unlock()
+
+	miner, ok := allMiners.all[pubKeyToString(report.Key)]
+	if !ok {
+		return unknownKeyError
 	}
-	*addrSet = minerAddresses[:n]
+	miner.Degree = report.Degree
 
 	return nil
 }
 
+// The original strategy: a shuffle seeded by the caller's key
+func selectDeterministic(self *Miner, candidates []*Miner, n int) []*Miner {
+	deterministicRandomNumber := self.Key.X.Int64() % 32
+	r := rand.New(rand.NewSource(deterministicRandomNumber))
+	return shuffled(candidates, r)[:n]
+}
+
+func selectRandom(self *Miner, candidates []*Miner, n int) []*Miner {
+	return shuffled(candidates, nil)[:n]
+}
+
+// The n miners that follow self on the ring of addresses
+func selectRing(self *Miner, candidates []*Miner, n int) []*Miner {
+	start := ringPosition(self, candidates)
+	selected := make([]*Miner, n)
+	for i := range selected {
+		selected[i] = candidates[(start+i)%len(candidates)]
+	}
+	return selected
+}
+
+// Half of n from the ring around self, alternating successor and
+// predecessor, the rest random
+func selectSmallWorld(self *Miner, candidates []*Miner, n int) []*Miner {
+	start := ringPosition(self, candidates)
+	taken := make(map[*Miner]bool)
+	selected := make([]*Miner, 0, n)
+
+	for i := 0; len(selected) < (n+1)/2; i++ {
+		// start is the successor, start-1 the predecessor
+		offset := i / 2
+		if i%2 == 1 {
+			offset = -offset - 1
+		}
+		miner := candidates[((start+offset)%len(candidates)+len(candidates))%len(candidates)]
+		if !taken[miner] {
+			taken[miner] = true
+			selected = append(selected, miner)
+		}
+	}
+
+	for _, miner := range shuffled(candidates, nil) {
+		if len(selected) == n {
+			break
+		}
+		if !taken[miner] {
+			selected = append(selected, miner)
+		}
+	}
+	return selected
+}
+
+// Miners in the subnet of self first, then the others, both in random order
+func selectLocality(self *Miner, candidates []*Miner, n int) []*Miner {
+	bucket := subnet(self.Address)
+	near := make([]*Miner, 0)
+	far := make([]*Miner, 0)
+	for _, miner := range shuffled(candidates, nil) {
+		if bucket != "" && subnet(miner.Address) == bucket {
+			near = append(near, miner)
+		} else {
+			far = append(far, miner)
+		}
+	}
+	return append(near, far...)[:n]
+}
+
+// The miners with the lowest degree, ties broken at random
+func selectLeastConnected(self *Miner, candidates []*Miner, n int) []*Miner {
+	miners := shuffled(candidates, nil)
+	sort.SliceStable(miners, func(i, j int) bool { return miners[i].Degree < miners[j].Degree })
+	return miners[:n]
+}
+
+// Returns a shuffled copy of miners, using r or the global source if r is nil
+func shuffled(miners []*Miner, r *rand.Rand) []*Miner {
+	copied := append([]*Miner{}, miners...)
+	intn := rand.Intn
+	if r != nil {
+		intn = r.Intn
+	}
+	for n := len(copied); n > 0; n-- {
+		randIndex := intn(n)
+		copied[n-1], copied[randIndex] = copied[randIndex], copied[n-1]
+	}
+	return copied
+}
+
+// Returns the index of the first of candidates that comes after self
+func ringPosition(self *Miner, candidates []*Miner) int {
+	addr := self.Address.String()
+	return sort.Search(len(candidates), func(i int) bool { return candidates[i].Address.String() > addr })
+}
+
+// Returns the subnet of addr as a string, or "" if it has no IP
+func subnet(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.IP == nil {
+		return ""
+	}
+	if ip := tcpAddr.IP.To4(); ip != nil {
+		return ip.Mask(net.CIDRMask(LOCALITY_PREFIX_V4, 32)).String()
+	}
+	return tcpAddr.IP.Mask(net.CIDRMask(LOCALITY_PREFIX_V6, 128)).String()
+}
+
+// Returns the public key the miner listening on addr registered with.
+// Miners use it to check that a peer owns the key it registered.
+//
+// Returns:
+// - UnknownAddressError if no registered miner has this address.
+func (s *RServer) GetKey(addr string, key *ecdsa.PublicKey) error {
+	allMiners.RLock()
+	defer allMiners.RUnlock()
+
+	for _, miner := range allMiners.all {
+		if miner.Address.String() == addr {
+			*key = miner.Key
+			return nil
+		}
+	}
+
+	return UnknownAddressError(addr)
+}
+
 // The server also listens for heartbeats from known miners. A miner must
 // send a heartbeat to the server every HeartBeat milliseconds
 // (specified in settings from server) after calling Register, otherwise
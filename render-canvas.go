@@ -0,0 +1,79 @@
+/*
+
+Renders the canvas to a PNG on the miner, without a browser.
+
+Usage:
+go run render-canvas.go -key name [-b blockHash] [-size maxSize] [-o out.png]
+  -key string
+    	Name of the key to use from the key store (required)
+  -keydir string
+    	Key store directory (default "./keys")
+  -b string
+    	Block to render the canvas at (default: tip of the longest chain)
+  -size int
+    	Longest side of the image in pixels, for thumbnails (default: full size)
+  -o string
+    	Output file (default "canvas.png")
+
+Reads the miner address from ./ip-ports.txt. The private key is loaded from
+the key store (see misc/keytool.go), with the passphrase taken from
+$INKCOIN_PASSPHRASE or stdin.
+*/
+
+package main
+
+import (
+	"./blockartlib"
+	"./keystore"
+)
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func main() {
+	blockHash := flag.String("b", "", "Block to render the canvas at (default: tip of the longest chain)")
+	maxSize := flag.Int("size", 0, "Longest side of the image in pixels, for thumbnails (default: full size)")
+	out := flag.String("o", "canvas.png", "Output file")
+	keyName := flag.String("key", "", "Name of the key to use from the key store (required)")
+	keyDir := flag.String("keydir", keystore.DEFAULT_DIR, "Key store directory")
+	flag.Parse()
+
+	if *keyName == "" {
+		fmt.Println("Usage: go run render-canvas.go -key name [-b blockHash] [-size maxSize] [-o out.png]")
+		return
+	}
+
+	// Read file content and cast to string
+	ipPortBytes, err := ioutil.ReadFile("./ip-ports.txt")
+	checkError(err)
+	ipPortString := string(ipPortBytes[:])
+
+	// Parse ip-port from content string
+	minerAddr := strings.Split(ipPortString, "\n")[0]
+
+	passphrase, err := keystore.ReadPassphrase()
+	checkError(err)
+	privKey, err := keystore.New(*keyDir).Load(*keyName, passphrase)
+	checkError(err)
+
+	png, err := blockartlib.RenderPNG(minerAddr, *privKey, *blockHash, *maxSize)
+	checkError(err)
+
+	err = ioutil.WriteFile(*out, png, 0644)
+	checkError(err)
+
+	fmt.Printf("Wrote %s (%d bytes)\n", *out, len(png))
+}
+
+// If error is non-nil, print it out and exit.
+func checkError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error ", err.Error())
+		os.Exit(1)
+	}
+}
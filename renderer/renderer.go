@@ -0,0 +1,215 @@
+/*
+
+This package rasterizes a canvas into an image, without a browser.
+
+Shapes are turned into pixels with the same shapelib geometry the miners use
+to check for overlaps, so what ends up in the PNG is exactly the area each
+shape paid ink for. The outline is painted in the stroke color and the rest
+of the shape in the fill color.
+
+Public functions:
+
+	LiveOps(chain []blockchain.Block) -> []blockchain.Operation
+
+	Render(ops []blockchain.Operation, xMax, yMax int) -> *image.RGBA
+
+	RenderPNG(w io.Writer, ops []blockchain.Operation, xMax, yMax, maxDim int) -> error
+
+	Thumbnail(img image.Image, maxDim int) -> *image.RGBA
+
+	ParseColor(s string) -> color.RGBA, bool
+
+	AnimatedSVG(w io.Writer, events []libminer.CanvasEvent, xMax, yMax int, frame time.Duration) -> error
+
+	AnimatedGIF(w io.Writer, events []libminer.CanvasEvent, xMax, yMax, maxDim int, frame time.Duration) -> error
+
+*/
+
+package renderer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+
+	"../blockchain"
+	"../shapelib"
+	"../utils"
+)
+
+// Canvas background
+var Background = color.RGBA{255, 255, 255, 255}
+
+// SVG color keywords the art apps use. Anything else has to be #rgb or #rrggbb.
+var namedColors = map[string]color.RGBA{
+	"black":   {0, 0, 0, 255},
+	"white":   {255, 255, 255, 255},
+	"red":     {255, 0, 0, 255},
+	"green":   {0, 128, 0, 255},
+	"lime":    {0, 255, 0, 255},
+	"blue":    {0, 0, 255, 255},
+	"yellow":  {255, 255, 0, 255},
+	"orange":  {255, 165, 0, 255},
+	"purple":  {128, 0, 128, 255},
+	"pink":    {255, 192, 203, 255},
+	"brown":   {165, 42, 42, 255},
+	"gray":    {128, 128, 128, 255},
+	"grey":    {128, 128, 128, 255},
+	"cyan":    {0, 255, 255, 255},
+	"magenta": {255, 0, 255, 255},
+	"navy":    {0, 0, 128, 255},
+	"teal":    {0, 128, 128, 255},
+	"maroon":  {128, 0, 0, 255},
+	"olive":   {128, 128, 0, 255},
+	"silver":  {192, 192, 192, 255},
+}
+
+// Returns the shapes that are still on the canvas at the end of chain, in the
+// order they were added.
+func LiveOps(chain []blockchain.Block) []blockchain.Operation {
+	live := utils.LiveOperations(utils.ChainOperations(chain))
+	ops := make([]blockchain.Operation, len(live))
+	for i, opInfo := range live {
+		ops[i] = opInfo.Op
+	}
+	return ops
+}
+
+// Paints ops, in order, onto a blank canvas of the given size. Ops that can't
+// be parsed are skipped; they can't be in a valid chain anyway.
+func Render(ops []blockchain.Operation, xMax, yMax int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, xMax+1, yMax+1))
+	draw.Draw(img, img.Bounds(), &image.Uniform{Background}, image.ZP, draw.Src)
+
+	for _, op := range ops {
+		if op.OpType == blockchain.DELETE {
+			continue
+		}
+
+		// A batch is drawn shape by shape, each in its own colors
+		shapes := []blockchain.Operation{op}
+		if op.OpType == blockchain.BATCH {
+			var err error
+			if shapes, err = blockchain.BatchShapes(op); err != nil {
+				fmt.Println("Render:: skipping bad batch:", err)
+				continue
+			}
+		}
+
+		for _, shape := range shapes {
+			if err := drawOp(img, shape, xMax, yMax); err != nil {
+				fmt.Println("Render:: skipping bad shape", shape.SVGString, ":", err)
+			}
+		}
+	}
+	return img
+}
+
+// Renders ops and writes them as a PNG. If maxDim > 0 the image is scaled
+// down so neither side is longer than maxDim.
+func RenderPNG(w io.Writer, ops []blockchain.Operation, xMax, yMax, maxDim int) error {
+	var img image.Image = Render(ops, xMax, yMax)
+	if maxDim > 0 {
+		img = Thumbnail(img, maxDim)
+	}
+	return png.Encode(w, img)
+}
+
+// Scales img down (nearest neighbour) so neither side is longer than maxDim.
+// Images that already fit are copied as is.
+func Thumbnail(img image.Image, maxDim int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	scale := 1.0
+	if w > maxDim || h > maxDim {
+		if w >= h {
+			scale = float64(maxDim) / float64(w)
+		} else {
+			scale = float64(maxDim) / float64(h)
+		}
+	}
+
+	tw, th := int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		for x := 0; x < tw; x++ {
+			thumb.Set(x, y, img.At(b.Min.X+int(float64(x)/scale), b.Min.Y+int(float64(y)/scale)))
+		}
+	}
+	return thumb
+}
+
+// Parses an SVG color. Returns false for "transparent" (or anything that
+// shouldn't be painted). Unknown colors are painted black.
+func ParseColor(s string) (color.RGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" || s == "transparent" || s == "none" {
+		return color.RGBA{}, false
+	}
+
+	if c, ok := namedColors[s]; ok {
+		return c, true
+	}
+
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		if len(hex) == 6 {
+			if v, err := strconv.ParseUint(hex, 16, 32); err == nil {
+				return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+			}
+		}
+	}
+
+	return namedColors["black"], true
+}
+
+// Paints the fill, then the outline of a single op
+func drawOp(img *image.RGBA, op blockchain.Operation, xMax, yMax int) error {
+	shape, err := utils.GetShape(op, xMax, yMax)
+	if err != nil {
+		return err
+	}
+
+	if fill, ok := ParseColor(op.Fill); ok {
+		paint(img, shape.SubArray(), fill)
+	}
+
+	if stroke, ok := ParseColor(op.Stroke); ok {
+		paint(img, outline(shape).SubArray(), stroke)
+	}
+	return nil
+}
+
+// Returns the unfilled version of shape, which only covers its outline
+func outline(shape shapelib.Shape) shapelib.Shape {
+	switch s := shape.(type) {
+	case shapelib.Path:
+		return shapelib.NewPath(s.Points, false, s.StrokeFilled)
+	case shapelib.Circle:
+		return shapelib.NewCircle(s.C.X, s.C.Y, s.R, false, s.StrokeFilled)
+	default:
+		return shape
+	}
+}
+
+func paint(img *image.RGBA, sub shapelib.PixelSubArray, c color.RGBA) {
+	sub.ForEachPixel(func(x, y int) {
+		img.SetRGBA(x, y, c)
+	})
+}
@@ -0,0 +1,120 @@
+/*
+
+Timelapse export of the canvas history. Both formats are built from the
+canvas events of the longest chain (see libminer.CanvasEvent), so each shape
+appears and disappears at the index of the block its ADD or DELETE is in.
+
+*/
+
+package renderer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+
+	"../blockchain"
+	"../libminer"
+	"../utils"
+)
+
+// Writes an SVG document where every shape is shown and hidden with SMIL
+// <set> animations at blockIndex * frame.
+func AnimatedSVG(w io.Writer, events []libminer.CanvasEvent, xMax, yMax int, frame time.Duration) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" version=\"1.1\">\n", xMax, yMax)
+	fmt.Fprintf(&buf, "\t<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+
+	// Each shape gets one group; its DELETE adds a second <set> to it
+	groups := make(map[string]*bytes.Buffer)
+	order := make([]string, 0)
+	for _, event := range events {
+		begin := fmt.Sprintf("%.3fs", (time.Duration(event.BlockIndex) * frame).Seconds())
+
+		switch event.Type {
+		case libminer.EVENT_SHAPE_ADDED:
+			group, ok := groups[event.ShapeHash]
+			if !ok {
+				group = new(bytes.Buffer)
+				groups[event.ShapeHash] = group
+				order = append(order, event.ShapeHash)
+				fmt.Fprintf(group, "\t\t%s\n", utils.GetHTMLSVGString(event.Op))
+			}
+			fmt.Fprintf(group, "\t\t<set attributeName=\"visibility\" to=\"visible\" begin=\"%s\" fill=\"freeze\"/>\n", begin)
+		case libminer.EVENT_SHAPE_DELETED:
+			if group, ok := groups[event.ShapeHash]; ok {
+				fmt.Fprintf(group, "\t\t<set attributeName=\"visibility\" to=\"hidden\" begin=\"%s\" fill=\"freeze\"/>\n", begin)
+			}
+		}
+	}
+
+	for _, shapeHash := range order {
+		fmt.Fprintf(&buf, "\t<g id=\"shape-%s\" visibility=\"hidden\">\n", shapeHash)
+		buf.Write(groups[shapeHash].Bytes())
+		buf.WriteString("\t</g>\n")
+	}
+	buf.WriteString("</svg>\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Writes an animated GIF with one frame per block. If maxDim > 0 the frames
+// are scaled down so neither side is longer than maxDim.
+func AnimatedGIF(w io.Writer, events []libminer.CanvasEvent, xMax, yMax, maxDim int, frame time.Duration) error {
+	anim := &gif.GIF{}
+	delay := int(frame / (10 * time.Millisecond))
+
+	order := make([]string, 0)
+	seen := make(map[string]bool)
+	live := make(map[string]blockchain.Operation)
+
+	addFrame := func() {
+		ops := make([]blockchain.Operation, 0, len(live))
+		for _, shapeHash := range order {
+			if op, ok := live[shapeHash]; ok {
+				ops = append(ops, op)
+			}
+		}
+
+		var img image.Image = Render(ops, xMax, yMax)
+		if maxDim > 0 {
+			img = Thumbnail(img, maxDim)
+		}
+
+		paletted := image.NewPaletted(img.Bounds(), palette.WebSafe)
+		draw.Draw(paletted, paletted.Bounds(), img, img.Bounds().Min, draw.Src)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, delay)
+	}
+
+	blockIndex := -1
+	for _, event := range events {
+		if event.BlockIndex != blockIndex && blockIndex != -1 {
+			addFrame()
+		}
+		blockIndex = event.BlockIndex
+
+		switch event.Type {
+		case libminer.EVENT_SHAPE_ADDED:
+			if !seen[event.ShapeHash] {
+				seen[event.ShapeHash] = true
+				order = append(order, event.ShapeHash)
+			}
+			live[event.ShapeHash] = event.Op
+		case libminer.EVENT_SHAPE_DELETED:
+			delete(live, event.ShapeHash)
+		}
+	}
+	addFrame()
+
+	// Hold the finished canvas a little longer before looping
+	anim.Delay[len(anim.Delay)-1] *= 5
+
+	return gif.EncodeAll(w, anim)
+}
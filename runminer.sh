@@ -1,8 +1,14 @@
 #!/bin/bash
-# Use to run a miner
+# Use to run a miner. Its key is kept in the key store as "miner" and made on
+# the first run. The passphrase is taken from $INKCOIN_PASSPHRASE, or asked for.
 
-LASTIFS=$IFS
-IFS=$'\n'
-result=($(go run misc/encrypt.go))
-IFS=$LASTIFS
-bash -c "${result[3]}"
+if [ -z "$INKCOIN_PASSPHRASE" ]; then
+	read -s -p "Passphrase: " INKCOIN_PASSPHRASE
+	echo
+	export INKCOIN_PASSPHRASE
+fi
+
+if [ ! -f keys/miner.json ]; then
+	go run misc/keytool.go generate miner || exit 1
+fi
+go run ink-miner.go -server 127.0.0.1:12345 -key miner
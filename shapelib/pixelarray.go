@@ -231,3 +231,32 @@ func (a PixelSubArray) PixelsFilled() int {
 
 	return sum
 }
+
+// Check if the pixel at the (x, y) canvas co-ordinates is filled
+func (a PixelSubArray) HasPixel(x, y int) bool {
+	xByte := x/8 - a.xStartByte
+	yRow := y - a.yStart
+	if x < 0 || yRow < 0 || yRow >= len(a.bytes) || xByte < 0 || xByte >= len(a.bytes[yRow]) {
+		return false
+	}
+
+	return (a.bytes[yRow][xByte]>>uint(x%8))&1 == 1
+}
+
+// Calls f with the (x, y) canvas co-ordinates of every filled pixel
+func (a PixelSubArray) ForEachPixel(f func(x, y int)) {
+	for y := 0; y < len(a.bytes); y++ {
+		for x := 0; x < len(a.bytes[y]); x++ {
+			b := a.bytes[y][x]
+			if b == 0 {
+				continue
+			}
+
+			for bit := uint(0); bit < 8; bit++ {
+				if (b>>bit)&1 == 1 {
+					f((a.xStartByte+x)*8+int(bit), a.yStart+y)
+				}
+			}
+		}
+	}
+}
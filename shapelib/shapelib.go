@@ -25,6 +25,8 @@ Public types and methods:
 	PixelSubArray
 	  Print()
 	  PixelsFilled() -> int
+	  ForEachPixel(f func(x, y int))
+	  HasPixel(x, y int) -> bool
 
 	Point
 
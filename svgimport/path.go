@@ -0,0 +1,321 @@
+package svgimport
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Number of line segments a curve is flattened into
+const CURVE_SEGMENTS = 8
+
+// A point before it is rounded onto the pixel grid
+type point struct {
+	X, Y float64
+}
+
+// One subpath of a path: the points it visits, in order
+type subpath struct {
+	Points []point
+	Closed bool
+}
+
+// Scanner over the d attribute of a <path>
+type pathScanner struct {
+	d   string
+	pos int
+}
+
+func (s *pathScanner) skipSeparators() {
+	for s.pos < len(s.d) && strings.IndexByte(" \t\r\n,", s.d[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+func (s *pathScanner) done() bool {
+	s.skipSeparators()
+	return s.pos >= len(s.d)
+}
+
+// Returns the next command letter, or 0 if the next token is a number
+func (s *pathScanner) command() byte {
+	s.skipSeparators()
+	if s.pos < len(s.d) {
+		c := s.d[s.pos]
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			if c != 'e' && c != 'E' {
+				s.pos++
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+// Reads one number. Handles the compact forms editors write, like "10-5"
+// and ".5.5".
+func (s *pathScanner) number() (float64, error) {
+	s.skipSeparators()
+	start := s.pos
+	if s.pos < len(s.d) && (s.d[s.pos] == '-' || s.d[s.pos] == '+') {
+		s.pos++
+	}
+
+	seenDot, seenExp := false, false
+	for s.pos < len(s.d) {
+		c := s.d[s.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !seenDot && !seenExp:
+			seenDot = true
+		case (c == 'e' || c == 'E') && !seenExp:
+			seenExp = true
+			if s.pos+1 < len(s.d) && (s.d[s.pos+1] == '-' || s.d[s.pos+1] == '+') {
+				s.pos++
+			}
+		default:
+			goto end
+		}
+		s.pos++
+	}
+end:
+	v, err := strconv.ParseFloat(s.d[start:s.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad number at %d in path %q", start, s.d)
+	}
+	return v, nil
+}
+
+// Arc flags are single digits that may be written without separators
+func (s *pathScanner) flag() (bool, error) {
+	s.skipSeparators()
+	if s.pos < len(s.d) && (s.d[s.pos] == '0' || s.d[s.pos] == '1') {
+		s.pos++
+		return s.d[s.pos-1] == '1', nil
+	}
+	return false, fmt.Errorf("bad arc flag at %d in path %q", s.pos, s.d)
+}
+
+func (s *pathScanner) numbers(n int) ([]float64, error) {
+	values := make([]float64, n)
+	for i := range values {
+		v, err := s.number()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// Parses the d attribute of a <path> into subpaths made only of straight
+// lines. Curves and arcs are flattened into CURVE_SEGMENTS lines each.
+func parsePathData(d string) ([]subpath, error) {
+	s := &pathScanner{d: d}
+	subpaths := make([]subpath, 0)
+
+	var cur, start, lastCtrl point
+	var cmd, prevCmd byte
+
+	lineTo := func(p point) {
+		sp := &subpaths[len(subpaths)-1]
+		sp.Points = append(sp.Points, p)
+		cur = p
+	}
+
+	for !s.done() {
+		if c := s.command(); c != 0 {
+			cmd = c
+		} else if cmd == 0 {
+			return nil, fmt.Errorf("path %q doesn't start with a command", d)
+		} else if cmd == 'M' {
+			// Coordinates following a moveto are implicit linetos
+			cmd = 'L'
+		} else if cmd == 'm' {
+			cmd = 'l'
+		}
+
+		rel := cmd >= 'a'
+		abs := func(x, y float64) point {
+			if rel {
+				return point{cur.X + x, cur.Y + y}
+			}
+			return point{x, y}
+		}
+
+		if len(subpaths) == 0 && cmd != 'M' && cmd != 'm' {
+			return nil, fmt.Errorf("path %q doesn't start with a moveto", d)
+		}
+
+		switch cmd {
+		case 'M', 'm':
+			v, err := s.numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			cur = abs(v[0], v[1])
+			start = cur
+			subpaths = append(subpaths, subpath{Points: []point{cur}})
+		case 'L', 'l':
+			v, err := s.numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			lineTo(abs(v[0], v[1]))
+		case 'H', 'h':
+			v, err := s.number()
+			if err != nil {
+				return nil, err
+			}
+			if rel {
+				v += cur.X
+			}
+			lineTo(point{v, cur.Y})
+		case 'V', 'v':
+			v, err := s.number()
+			if err != nil {
+				return nil, err
+			}
+			if rel {
+				v += cur.Y
+			}
+			lineTo(point{cur.X, v})
+		case 'C', 'c', 'S', 's':
+			var c1 point
+			var v []float64
+			var err error
+			if cmd == 'C' || cmd == 'c' {
+				if v, err = s.numbers(6); err != nil {
+					return nil, err
+				}
+				c1 = abs(v[0], v[1])
+				v = v[2:]
+			} else {
+				if v, err = s.numbers(4); err != nil {
+					return nil, err
+				}
+				// Reflect the previous control point
+				c1 = cur
+				if strings.IndexByte("CcSs", prevCmd) >= 0 {
+					c1 = point{2*cur.X - lastCtrl.X, 2*cur.Y - lastCtrl.Y}
+				}
+			}
+			c2, end := abs(v[0], v[1]), abs(v[2], v[3])
+			p0 := cur
+			for i := 1; i <= CURVE_SEGMENTS; i++ {
+				t := float64(i) / CURVE_SEGMENTS
+				mt := 1 - t
+				lineTo(point{
+					mt*mt*mt*p0.X + 3*mt*mt*t*c1.X + 3*mt*t*t*c2.X + t*t*t*end.X,
+					mt*mt*mt*p0.Y + 3*mt*mt*t*c1.Y + 3*mt*t*t*c2.Y + t*t*t*end.Y})
+			}
+			lastCtrl = c2
+		case 'Q', 'q', 'T', 't':
+			var c1, end point
+			if cmd == 'Q' || cmd == 'q' {
+				v, err := s.numbers(4)
+				if err != nil {
+					return nil, err
+				}
+				c1, end = abs(v[0], v[1]), abs(v[2], v[3])
+			} else {
+				v, err := s.numbers(2)
+				if err != nil {
+					return nil, err
+				}
+				c1 = cur
+				if strings.IndexByte("QqTt", prevCmd) >= 0 {
+					c1 = point{2*cur.X - lastCtrl.X, 2*cur.Y - lastCtrl.Y}
+				}
+				end = abs(v[0], v[1])
+			}
+			p0 := cur
+			for i := 1; i <= CURVE_SEGMENTS; i++ {
+				t := float64(i) / CURVE_SEGMENTS
+				mt := 1 - t
+				lineTo(point{
+					mt*mt*p0.X + 2*mt*t*c1.X + t*t*end.X,
+					mt*mt*p0.Y + 2*mt*t*c1.Y + t*t*end.Y})
+			}
+			lastCtrl = c1
+		case 'A', 'a':
+			v, err := s.numbers(3)
+			if err != nil {
+				return nil, err
+			}
+			large, err := s.flag()
+			if err != nil {
+				return nil, err
+			}
+			sweep, err := s.flag()
+			if err != nil {
+				return nil, err
+			}
+			e, err := s.numbers(2)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range flattenArc(cur, abs(e[0], e[1]), v[0], v[1], v[2], large, sweep) {
+				lineTo(p)
+			}
+		case 'Z', 'z':
+			subpaths[len(subpaths)-1].Closed = true
+			cur = start
+		default:
+			return nil, fmt.Errorf("unsupported path command %c", cmd)
+		}
+		prevCmd = cmd
+	}
+
+	return subpaths, nil
+}
+
+// Flattens an elliptical arc from p0 to p1 into line segments, using the
+// endpoint to center conversion from the SVG spec (appendix F.6.5)
+func flattenArc(p0, p1 point, rx, ry, angle float64, large, sweep bool) []point {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p1 {
+		return []point{p1}
+	}
+
+	phi := angle * math.Pi / 180
+	cosPhi, sinPhi := math.Cos(phi), math.Sin(phi)
+
+	dx, dy := (p0.X-p1.X)/2, (p0.Y-p1.Y)/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+
+	// Scale up radii that are too small to reach p1
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+
+	cx := cosPhi*cx1 - sinPhi*cy1 + (p0.X+p1.X)/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (p0.Y+p1.Y)/2
+
+	theta1 := math.Atan2((y1-cy1)/ry, (x1-cx1)/rx)
+	delta := math.Atan2((-y1-cy1)/ry, (-x1-cx1)/rx) - theta1
+	if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	} else if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	}
+
+	points := make([]point, 0, CURVE_SEGMENTS)
+	for i := 1; i <= CURVE_SEGMENTS; i++ {
+		t := theta1 + delta*float64(i)/CURVE_SEGMENTS
+		x, y := rx*math.Cos(t), ry*math.Sin(t)
+		points = append(points, point{cosPhi*x - sinPhi*y + cx, sinPhi*x + cosPhi*y + cy})
+	}
+	points[len(points)-1] = p1
+	return points
+}
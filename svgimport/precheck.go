@@ -0,0 +1,102 @@
+package svgimport
+
+import (
+	"../libminer"
+	"../shapelib"
+	"../utils"
+)
+
+// Canvas size, same as blockartlib.CanvasSettings
+type CanvasSettings struct {
+	CanvasXMax uint32
+	CanvasYMax uint32
+}
+
+// Checks shapes the way the miners will before anything is submitted:
+// bounds, overlaps with shapes of other owners in existing, and the total
+// ink against inkRemaining. Returns the total ink cost.
+// Possible Errors:
+// - InvalidShapeSvgStringError
+// - OutOfBoundsError
+// - ShapeOverlapError
+// - InsufficientInkError
+func PreCheck(shapes []Shape, existing []libminer.CanvasShape, pubKey string,
+	inkRemaining uint32, settings CanvasSettings) (cost int, err error) {
+	xMax, yMax := int(settings.CanvasXMax), int(settings.CanvasYMax)
+
+	// Shapes of the same owner may overlap, so only other owners count
+	pixelarr := shapelib.NewPixelArray(xMax, yMax)
+	for _, canvasShape := range existing {
+		if canvasShape.Owner == pubKey {
+			continue
+		}
+
+		existingShapes, err := utils.GetShapes(canvasShape.Op, xMax, yMax)
+		if err != nil {
+			continue
+		}
+		for _, shape := range existingShapes {
+			pixelarr.MergeSubArray(shape.SubArray())
+		}
+	}
+
+	for _, s := range shapes {
+		shape, err := utils.GetShape(s.Op, xMax, yMax)
+		if err != nil {
+			return cost, err
+		}
+
+		subarr, shapeCost := shape.SubArrayAndCost()
+		if pixelarr.HasConflict(subarr) {
+			return cost, libminer.ShapeOverlapError(s.Op.SVGString)
+		}
+		cost += shapeCost
+	}
+
+	if cost > int(inkRemaining) {
+		return cost, libminer.InsufficientInkError(inkRemaining)
+	}
+	return cost, nil
+}
+
+// Splits shapes into batches of at most maxShapes that the miners will
+// accept: the shapes of a batch must not overlap each other, so overlapping
+// shapes go into separate batches. A shape is never put in an earlier batch
+// than a shape before it that it overlaps, so they are still drawn in
+// document order.
+// Possible Errors:
+// - InvalidShapeSvgStringError
+// - OutOfBoundsError
+func SplitBatches(shapes []Shape, maxShapes int, settings CanvasSettings) ([][]Shape, error) {
+	xMax, yMax := int(settings.CanvasXMax), int(settings.CanvasYMax)
+
+	batches := make([][]Shape, 0)
+	batcharrs := make([]shapelib.PixelArray, 0)
+	for _, s := range shapes {
+		shape, err := utils.GetShape(s.Op, xMax, yMax)
+		if err != nil {
+			return batches, err
+		}
+		subarr, _ := shape.SubArrayAndCost()
+
+		// First batch after the last one it overlaps that still has room
+		first := 0
+		for i := len(batches) - 1; i >= 0; i-- {
+			if batcharrs[i].HasConflict(subarr) {
+				first = i + 1
+				break
+			}
+		}
+		for first < len(batches) && len(batches[first]) >= maxShapes {
+			first++
+		}
+
+		if first == len(batches) {
+			batches = append(batches, make([]Shape, 0, maxShapes))
+			batcharrs = append(batcharrs, shapelib.NewPixelArray(xMax, yMax))
+		}
+		batches[first] = append(batches[first], s)
+		batcharrs[first].MergeSubArray(subarr)
+	}
+	return batches, nil
+}
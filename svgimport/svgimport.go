@@ -0,0 +1,376 @@
+/*
+
+This package converts SVG documents made in regular editors into operations
+the miners accept, so a whole drawing can be submitted as a batch.
+
+Supported elements are path, rect, circle, ellipse, line, polygon and
+polyline, inside any number of groups. Groups and elements may have a
+translate() transform; any other transform is reported as unsupported.
+Coordinates are rounded onto the pixel grid, curves and arcs are flattened
+into lines and ellipses into polygons. Paths that don't fit into the 128
+character limit are split into one operation per subpath, and open unfilled
+paths further into runs of consecutive segments.
+
+Public functions:
+
+	Parse(r io.Reader) -> []Shape, []error, error
+
+	PreCheck(shapes []Shape, existing []libminer.CanvasShape, pubKey string,
+		inkRemaining uint32, settings CanvasSettings) -> cost int, error
+
+*/
+
+package svgimport
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"../blockchain"
+	"../utils"
+)
+
+/*******************
+* TYPE_DEFINITIONS *
+*******************/
+
+// Number of points an ellipse is approximated with, at most. Fewer are used
+// if the path wouldn't fit into utils.MAX_SVG_LEN.
+const ELLIPSE_POINTS = 24
+
+// One operation to submit, and the element it came from
+type Shape struct {
+	Op blockchain.Operation
+	// Element name and index in the document, e.g. "rect #3"
+	Source string
+}
+
+// Contains the name of an element that can't be converted
+type UnsupportedElementError string
+
+func (e UnsupportedElementError) Error() string {
+	return fmt.Sprintf("SVG import: unsupported element [%s]", string(e))
+}
+
+// Contains a transform attribute that isn't a plain translate
+type UnsupportedTransformError string
+
+func (e UnsupportedTransformError) Error() string {
+	return fmt.Sprintf("SVG import: unsupported transform [%s]", string(e))
+}
+
+// Contains the source of a shape that can't be made to fit utils.MAX_SVG_LEN
+type ShapeTooLongError string
+
+func (e ShapeTooLongError) Error() string {
+	return fmt.Sprintf("SVG import: shape too long to submit [%s]", string(e))
+}
+
+// Presentation attributes inherited from the enclosing groups
+type style struct {
+	dx, dy float64
+	fill   string
+	stroke string
+}
+
+var reTranslate = regexp.MustCompile(`^\s*translate\(\s*([-+.\deE]+)(?:[\s,]+([-+.\deE]+))?\s*\)\s*$`)
+
+/***********************
+* FUNCTION_DEFINITIONS *
+***********************/
+
+// Converts the SVG document read from r. Elements that can't be converted are
+// left out and reported in skipped; err is only set if the document itself
+// can't be read.
+func Parse(r io.Reader) (shapes []Shape, skipped []error, err error) {
+	decoder := xml.NewDecoder(r)
+	stack := []style{{fill: "black", stroke: "transparent"}}
+	count := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return shapes, skipped, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			count++
+			name := t.Name.Local
+			source := fmt.Sprintf("%s #%d", name, count)
+
+			s, err := inherit(stack[len(stack)-1], t.Attr)
+			if err != nil {
+				skipped = append(skipped, fmt.Errorf("%s: %s", source, err))
+				decoder.Skip()
+				continue
+			}
+
+			switch name {
+			case "svg", "g", "a":
+				stack = append(stack, s)
+			case "defs", "clipPath", "mask", "symbol", "marker", "pattern",
+				"linearGradient", "radialGradient", "title", "desc", "metadata", "style":
+				// Not drawn
+				decoder.Skip()
+			default:
+				elemShapes, err := convert(name, attrMap(t.Attr), s, source)
+				if err != nil {
+					skipped = append(skipped, fmt.Errorf("%s: %s", source, err))
+				}
+				shapes = append(shapes, elemShapes...)
+				decoder.Skip()
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "svg", "g", "a":
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return shapes, skipped, nil
+}
+
+// Applies the transform and presentation attributes of an element on top of
+// the ones it inherits
+func inherit(parent style, attrs []xml.Attr) (style, error) {
+	s := parent
+	a := attrMap(attrs)
+
+	if transform, ok := a["transform"]; ok {
+		match := reTranslate.FindStringSubmatch(transform)
+		if match == nil {
+			return s, UnsupportedTransformError(transform)
+		}
+		tx, _ := strconv.ParseFloat(match[1], 64)
+		ty := 0.0
+		if match[2] != "" {
+			ty, _ = strconv.ParseFloat(match[2], 64)
+		}
+		s.dx, s.dy = s.dx+tx, s.dy+ty
+	}
+
+	// style="fill:...;stroke:..." wins over the attributes
+	for _, decl := range strings.Split(a["style"], ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) == 2 {
+			a[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	if fill, ok := a["fill"]; ok {
+		s.fill = color(fill)
+	}
+	if stroke, ok := a["stroke"]; ok {
+		s.stroke = color(stroke)
+	}
+	return s, nil
+}
+
+// Converts a single drawing element
+func convert(name string, a map[string]string, s style, source string) ([]Shape, error) {
+	num := func(key string) float64 {
+		v, _ := strconv.ParseFloat(strings.TrimSuffix(a[key], "px"), 64)
+		return v
+	}
+
+	var subpaths []subpath
+	switch name {
+	case "path":
+		var err error
+		if subpaths, err = parsePathData(a["d"]); err != nil {
+			return nil, err
+		}
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		subpaths = []subpath{{Points: []point{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}, Closed: true}}
+	case "line":
+		subpaths = []subpath{{Points: []point{{num("x1"), num("y1")}, {num("x2"), num("y2")}}}}
+	case "polygon", "polyline":
+		points, err := parsePoints(a["points"])
+		if err != nil {
+			return nil, err
+		}
+		subpaths = []subpath{{Points: points, Closed: name == "polygon"}}
+	case "circle":
+		return circleShape(num("cx")+s.dx, num("cy")+s.dy, num("r"), s, source), nil
+	case "ellipse":
+		rx, ry := num("rx"), num("ry")
+		if round(rx) == round(ry) {
+			return circleShape(num("cx")+s.dx, num("cy")+s.dy, rx, s, source), nil
+		}
+		return ellipseShape(num("cx")+s.dx, num("cy")+s.dy, rx, ry, s, source)
+	default:
+		return nil, UnsupportedElementError(name)
+	}
+
+	for i := range subpaths {
+		for j := range subpaths[i].Points {
+			subpaths[i].Points[j].X += s.dx
+			subpaths[i].Points[j].Y += s.dy
+		}
+	}
+	return pathShapes(subpaths, s, source)
+}
+
+func circleShape(cx, cy, r float64, s style, source string) []Shape {
+	op := blockchain.Operation{
+		OpType:    blockchain.ADD,
+		SVGString: fmt.Sprintf("circle x:%d y:%d r:%d", round(cx), round(cy), round(r)),
+		Fill:      s.fill,
+		Stroke:    s.stroke}
+	return []Shape{{Op: op, Source: source}}
+}
+
+// Approximates an ellipse with the most points that still fit
+func ellipseShape(cx, cy, rx, ry float64, s style, source string) ([]Shape, error) {
+	for n := ELLIPSE_POINTS; n >= 8; n -= 4 {
+		points := make([]point, n)
+		for i := range points {
+			t := 2 * math.Pi * float64(i) / float64(n)
+			points[i] = point{cx + rx*math.Cos(t), cy + ry*math.Sin(t)}
+		}
+
+		svgString := subpathString(subpath{Points: points, Closed: true})
+		if len(svgString) <= utils.MAX_SVG_LEN {
+			op := blockchain.Operation{OpType: blockchain.ADD, SVGString: svgString, Fill: s.fill, Stroke: s.stroke}
+			return []Shape{{Op: op, Source: source}}, nil
+		}
+	}
+	return nil, ShapeTooLongError(source)
+}
+
+// Turns subpaths into as few operations as will fit into utils.MAX_SVG_LEN
+func pathShapes(subpaths []subpath, s style, source string) ([]Shape, error) {
+	filled := s.fill != "transparent"
+	if filled {
+		// SVG fills open subpaths as if they were closed, the miners only
+		// accept closed ones
+		for i := range subpaths {
+			subpaths[i].Closed = true
+		}
+	}
+
+	newShape := func(svgString string) Shape {
+		op := blockchain.Operation{OpType: blockchain.ADD, SVGString: svgString, Fill: s.fill, Stroke: s.stroke}
+		return Shape{Op: op, Source: source}
+	}
+
+	// All subpaths in one operation
+	strs := make([]string, len(subpaths))
+	for i, sp := range subpaths {
+		strs[i] = subpathString(sp)
+	}
+	if whole := strings.Join(strs, " "); len(whole) <= utils.MAX_SVG_LEN {
+		return []Shape{newShape(whole)}, nil
+	}
+
+	// One operation per subpath, split further if it is open
+	shapes := make([]Shape, 0)
+	for i, sp := range subpaths {
+		if len(strs[i]) <= utils.MAX_SVG_LEN {
+			shapes = append(shapes, newShape(strs[i]))
+			continue
+		}
+		if sp.Closed {
+			return nil, ShapeTooLongError(source)
+		}
+
+		for _, run := range splitOpenSubpath(sp) {
+			shapes = append(shapes, newShape(run))
+		}
+	}
+	return shapes, nil
+}
+
+// Splits an open subpath into runs of consecutive segments that each fit into
+// utils.MAX_SVG_LEN. Each run starts where the previous one ended.
+func splitOpenSubpath(sp subpath) []string {
+	runs := make([]string, 0)
+	run := []point{sp.Points[0]}
+	for _, p := range sp.Points[1:] {
+		next := append(append([]point{}, run...), p)
+		if len(run) > 1 && len(subpathString(subpath{Points: next})) > utils.MAX_SVG_LEN {
+			runs = append(runs, subpathString(subpath{Points: run}))
+			run = []point{run[len(run)-1], p}
+		} else {
+			run = next
+		}
+	}
+	return append(runs, subpathString(subpath{Points: run}))
+}
+
+// Formats a subpath in the syntax utils.GetParsedSVG accepts. A closed
+// subpath ends with an explicit line back to its start rather than Z, since
+// Z always returns to the first point of the whole path.
+func subpathString(sp subpath) string {
+	parts := make([]string, 0, len(sp.Points)+2)
+	var prevX, prevY int
+	for i, p := range sp.Points {
+		x, y := round(p.X), round(p.Y)
+		if i == 0 {
+			parts = append(parts, fmt.Sprintf("M %d %d", x, y))
+		} else if x != prevX || y != prevY {
+			parts = append(parts, fmt.Sprintf("L %d %d", x, y))
+		}
+		prevX, prevY = x, y
+	}
+
+	if sp.Closed {
+		x, y := round(sp.Points[0].X), round(sp.Points[0].Y)
+		if x != prevX || y != prevY {
+			parts = append(parts, fmt.Sprintf("L %d %d", x, y))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Parses the points attribute of a polygon or polyline
+func parsePoints(attr string) ([]point, error) {
+	fields := strings.FieldsFunc(attr, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	if len(fields) < 4 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("bad points %q", attr)
+	}
+
+	points := make([]point, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		x, errX := strconv.ParseFloat(fields[i], 64)
+		y, errY := strconv.ParseFloat(fields[i+1], 64)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("bad points %q", attr)
+		}
+		points = append(points, point{x, y})
+	}
+	return points, nil
+}
+
+func attrMap(attrs []xml.Attr) map[string]string {
+	a := make(map[string]string)
+	for _, attr := range attrs {
+		a[attr.Name.Local] = attr.Value
+	}
+	return a
+}
+
+// SVG's "none" is "transparent" for the miners
+func color(c string) string {
+	c = strings.TrimSpace(c)
+	if c == "none" || c == "" {
+		return "transparent"
+	}
+	return c
+}
+
+func round(v float64) int {
+	return int(math.Floor(v + 0.5))
+}
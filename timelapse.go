@@ -0,0 +1,108 @@
+/*
+
+Exports a timelapse of the canvas, from the genesis block to the tip of the
+longest chain, as an animated SVG (SMIL) or GIF.
+
+Usage:
+go run timelapse.go -key name [-gif] [-frame ms] [-size maxSize] [-o file]
+  -key string
+    	Name of the key to use from the key store (required)
+  -keydir string
+    	Key store directory (default "./keys")
+  -gif
+    	Export an animated GIF instead of an SVG
+  -frame int
+    	Milliseconds per block (default 500)
+  -size int
+    	GIF only: longest side of the frames in pixels (default: full size)
+  -o string
+    	Output file (default "timelapse.svg" or "timelapse.gif")
+
+Reads the miner address from ./ip-ports.txt. The private key is loaded from
+the key store (see misc/keytool.go), with the passphrase taken from
+$INKCOIN_PASSPHRASE or stdin.
+*/
+
+package main
+
+import (
+	"./blockartlib"
+	"./keystore"
+	"./renderer"
+)
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	asGIF := flag.Bool("gif", false, "Export an animated GIF instead of an SVG")
+	frameMs := flag.Int("frame", 500, "Milliseconds per block")
+	maxSize := flag.Int("size", 0, "GIF only: longest side of the frames in pixels")
+	out := flag.String("o", "", "Output file")
+	keyName := flag.String("key", "", "Name of the key to use from the key store (required)")
+	keyDir := flag.String("keydir", keystore.DEFAULT_DIR, "Key store directory")
+	flag.Parse()
+
+	if *keyName == "" {
+		fmt.Println("Usage: go run timelapse.go -key name [-gif] [-frame ms] [-size maxSize] [-o file]")
+		return
+	}
+
+	if *out == "" {
+		if *asGIF {
+			*out = "timelapse.gif"
+		} else {
+			*out = "timelapse.svg"
+		}
+	}
+
+	// Read file content and cast to string
+	ipPortBytes, err := ioutil.ReadFile("./ip-ports.txt")
+	checkError(err)
+	ipPortString := string(ipPortBytes[:])
+
+	// Parse ip-port from content string
+	minerAddr := strings.Split(ipPortString, "\n")[0]
+
+	passphrase, err := keystore.ReadPassphrase()
+	checkError(err)
+	privKey, err := keystore.New(*keyDir).Load(*keyName, passphrase)
+	checkError(err)
+
+	// Open a canvas for its size
+	canvas, settings, err := blockartlib.OpenCanvas(minerAddr, *privKey)
+	checkError(err)
+	canvas.CloseCanvas()
+
+	events, tipHash, err := blockartlib.GetCanvasHistory(minerAddr, *privKey)
+	checkError(err)
+
+	file, err := os.Create(*out)
+	checkError(err)
+	defer file.Close()
+
+	xMax, yMax := int(settings.CanvasXMax), int(settings.CanvasYMax)
+	frame := time.Duration(*frameMs) * time.Millisecond
+	if *asGIF {
+		err = renderer.AnimatedGIF(file, events, xMax, yMax, *maxSize, frame)
+	} else {
+		err = renderer.AnimatedSVG(file, events, xMax, yMax, frame)
+	}
+	checkError(err)
+
+	fmt.Printf("Wrote %s (%d events up to %s)\n", *out, len(events), tipHash)
+}
+
+// If error is non-nil, print it out and exit.
+func checkError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error ", err.Error())
+		os.Exit(1)
+	}
+}
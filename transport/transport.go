@@ -0,0 +1,239 @@
+/*
+
+This package wraps miner-to-miner and art-node-to-miner connections in TLS,
+using certificates made from the ECDSA identities the network already uses.
+
+Every side presents a self-signed certificate for its own key pair, so the
+TLS handshake proves it holds the private key of the public key in the
+certificate. There are no certificate authorities: instead of checking a
+chain, the caller pins the key it expects (the key a miner registered with
+the server, or the art node's own key) with a KeyCheck. Once the handshake is
+done the connection is encrypted and tamper proof like any TLS connection.
+
+Public functions:
+
+	Certificate(privKey *ecdsa.PrivateKey) -> tls.Certificate, error
+
+	ServerConfig(privKey *ecdsa.PrivateKey, check KeyCheck) -> *tls.Config, error
+
+	ClientConfig(privKey *ecdsa.PrivateKey, check KeyCheck) -> *tls.Config, error
+
+	Dial(addr string, privKey *ecdsa.PrivateKey, check KeyCheck) -> *tls.Conn, error
+
+	Server(conn net.Conn, privKey *ecdsa.PrivateKey, check KeyCheck) -> *tls.Conn, error
+
+	PeerKey(conn *tls.Conn) -> *ecdsa.PublicKey
+
+	ExpectKey(pubKey *ecdsa.PublicKey) -> KeyCheck
+
+	SameKey(a, b *ecdsa.PublicKey) -> bool
+
+	IsTLS(conn net.Conn) -> bool, net.Conn, error
+
+*/
+
+package transport
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const (
+	// How long the TLS handshake may take before the connection is dropped
+	HANDSHAKE_TIMEOUT = 10 * time.Second
+	// Certificates are made fresh on every start, so this only has to
+	// outlast a run
+	CERT_VALIDITY = 10 * 365 * 24 * time.Hour
+	// First byte of a TLS record carrying a handshake message
+	TLS_HANDSHAKE_RECORD = 0x16
+)
+
+// Checks the key the other side proved it owns. Returning an error aborts
+// the handshake.
+type KeyCheck func(pubKey *ecdsa.PublicKey) error
+
+// Contains the key the other side presented, as hex
+type UnexpectedKeyError string
+
+func (e UnexpectedKeyError) Error() string {
+	return fmt.Sprintf("Unexpected peer key: %s", string(e))
+}
+
+// Makes a self-signed certificate for privKey
+func Certificate(privKey *ecdsa.PrivateKey) (tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "blockart"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(CERT_VALIDITY),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privKey.PublicKey, privKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: privKey}, nil
+}
+
+// Config for accepting connections. The client has to present a certificate
+// too, and its key has to pass check.
+func ServerConfig(privKey *ecdsa.PrivateKey, check KeyCheck) (*tls.Config, error) {
+	cert, err := Certificate(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		MinVersion:            tls.VersionTLS12,
+		VerifyPeerCertificate: verifyKey(check)}, nil
+}
+
+// Config for dialing. The server's key has to pass check.
+func ClientConfig(privKey *ecdsa.PrivateKey, check KeyCheck) (*tls.Config, error) {
+	cert, err := Certificate(privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// There is no chain to verify, the key is pinned by verifyKey
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyKey(check)}, nil
+}
+
+// Connects to addr and completes the handshake
+func Dial(addr string, privKey *ecdsa.PrivateKey, check KeyCheck) (*tls.Conn, error) {
+	config, err := ClientConfig(privKey, check)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: HANDSHAKE_TIMEOUT}
+	return tls.DialWithDialer(dialer, "tcp", addr, config)
+}
+
+// Completes the server side handshake on an accepted connection. Closes conn
+// if it fails.
+func Server(conn net.Conn, privKey *ecdsa.PrivateKey, check KeyCheck) (*tls.Conn, error) {
+	config, err := ServerConfig(privKey, check)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tlsConn := tls.Server(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Returns the key the other side of a completed handshake proved it owns
+func PeerKey(conn *tls.Conn) *ecdsa.PublicKey {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	pubKey, _ := certs[0].PublicKey.(*ecdsa.PublicKey)
+	return pubKey
+}
+
+// Returns a KeyCheck that only accepts pubKey
+func ExpectKey(pubKey *ecdsa.PublicKey) KeyCheck {
+	return func(got *ecdsa.PublicKey) error {
+		if !SameKey(pubKey, got) {
+			return UnexpectedKeyError(keyString(got))
+		}
+		return nil
+	}
+}
+
+func SameKey(a, b *ecdsa.PublicKey) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	return a.X.Cmp(b.X) == 0 && a.Y.Cmp(b.Y) == 0
+}
+
+// Peeks at the first byte of an accepted connection to tell TLS clients from
+// plaintext ones. The returned conn still has that byte to read.
+func IsTLS(conn net.Conn) (bool, net.Conn, error) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(HANDSHAKE_TIMEOUT))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return false, conn, err
+	}
+	return first[0] == TLS_HANDSHAKE_RECORD, &peekedConn{conn, reader}, nil
+}
+
+// A connection whose first bytes were read into a buffer
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// Checks the self-signed certificate the other side presented. The TLS
+// handshake already proved it holds the certificate's private key.
+func verifyKey(check KeyCheck) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("no certificate presented")
+		}
+
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		pubKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("certificate key is not ECDSA")
+		}
+		if err = cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+			return err
+		}
+
+		if check == nil {
+			return nil
+		}
+		return check(pubKey)
+	}
+}
+
+func keyString(pubKey *ecdsa.PublicKey) string {
+	if pubKey == nil {
+		return "none"
+	}
+	bytes, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%x", bytes)
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+
+	"../blockchain"
+)
+
+// Flattens the ops of chain into one list, in block order
+func ChainOperations(chain []blockchain.Block) []blockchain.OperationInfo {
+	ops := make([]blockchain.OperationInfo, 0)
+	for _, block := range chain {
+		ops = append(ops, block.OpHistory...)
+	}
+	return ops
+}
+
+// Resolves ADD/DELETE pairs: returns the ADDs in ops (in block order) that no
+// later DELETE or UPDATE refers to by its AddSig. Batches are expanded into
+// one ADD per shape, all carrying the OpSig of the batch, and a live UPDATE is
+// returned as the ADD of its new shape.
+func LiveOperations(ops []blockchain.OperationInfo) []blockchain.OperationInfo {
+	deleted := make(map[string]bool)
+	for _, opInfo := range ops {
+		if opInfo.Op.OpType == blockchain.DELETE || opInfo.Op.OpType == blockchain.UPDATE {
+			deleted[opInfo.AddSig] = true
+		}
+	}
+
+	live := make([]blockchain.OperationInfo, 0, len(ops))
+	for _, opInfo := range ops {
+		if opInfo.Op.OpType == blockchain.DELETE || deleted[opInfo.OpSig] {
+			continue
+		}
+
+		if opInfo.Op.OpType == blockchain.BATCH {
+			// Batches that can't be decoded can't be in a valid chain
+			shapes, _ := blockchain.BatchShapes(opInfo.Op)
+			for _, shape := range shapes {
+				member := opInfo
+				member.Op = shape
+				live = append(live, member)
+			}
+		} else {
+			if opInfo.Op.OpType == blockchain.UPDATE {
+				opInfo.AddSig = ""
+				opInfo.Op.OpType = blockchain.ADD
+			}
+			live = append(live, opInfo)
+		}
+	}
+	return live
+}
+
+// Returns the HTML SVG element of every live shape in ops, in block order.
+// Deleted shapes are left out rather than painted over in white.
+func GetLiveSVGStrings(ops []blockchain.OperationInfo) []string {
+	live := LiveOperations(ops)
+	elements := make([]string, len(live))
+	for i, opInfo := range live {
+		elements[i] = GetHTMLSVGString(opInfo.Op)
+	}
+	return elements
+}
+
+// Builds a standalone <svg> document of the canvas described by ops
+func GetSVGDocument(ops []blockchain.OperationInfo, canvasX, canvasY uint32) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" version=\"1.1\">\n", canvasX, canvasY)
+	for _, element := range GetLiveSVGStrings(ops) {
+		buf.WriteString("\t" + element + "\n")
+	}
+	buf.WriteString("</svg>\n")
+	return buf.String()
+}
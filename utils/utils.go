@@ -75,6 +75,28 @@ type SVGPath []SVGCommand
 // Given a blockchain.OperationInfo, returns the corresponding html svg element
 // i.e. <path d="M 0 0 H 10 10 v 20 Z" fill="transparent" stroke="red">
 func GetHTMLSVGString(op blockchain.Operation) string {
+	// A batch is drawn as a group of its shapes, and so is painted over
+	// when it is deleted
+	batch := op
+	if op.OpType == blockchain.DELETE {
+		batch = blockchain.DeletedOp(op)
+	}
+	if batch.OpType == blockchain.BATCH {
+		shapes, err := blockchain.BatchShapes(batch)
+		if err != nil {
+			fmt.Println("Bad batch:", err)
+			return ""
+		}
+		elements := make([]string, len(shapes))
+		for i, shape := range shapes {
+			if op.OpType == blockchain.DELETE {
+				shape.OpType = blockchain.DELETE
+			}
+			elements[i] = GetHTMLSVGString(shape)
+		}
+		return "<g>" + strings.Join(elements, "") + "</g>"
+	}
+
 	var fill, stroke string
 	if op.OpType == blockchain.DELETE {
 		fill = "white"
@@ -331,6 +353,62 @@ func GetParsedCirc(op blockchain.Operation, canvasX int, canvasY int) (shapelib.
 	return circ, nil
 }
 
+// Returns the shapelib.Shape for an operation, trying it as a path first and
+// then as a circle.
+// Possible Errors:
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - OutOfBoundsError
+func GetShape(op blockchain.Operation, canvasX int, canvasY int) (shapelib.Shape, error) {
+	pathlist, parsingErr := GetParsedSVG(op.SVGString)
+	if parsingErr == nil {
+		// Error is nil, should be parsable into shapelib.Path
+		return SVGToPoints(pathlist, canvasX, canvasY,
+			op.Fill != "transparent",
+			op.Stroke != "transparent")
+	}
+
+	// Try parsing it as a circle
+	circ, err := GetParsedCirc(op, canvasX, canvasY)
+	if err != nil {
+		fmt.Println("SVG string is neither circle nor path:", op.SVGString)
+		return circ, parsingErr
+	}
+
+	return circ, nil
+}
+
+// Returns the shapes drawn by an operation: every shape of a batch, or the
+// single shape of any other operation. The DELETE of a batch has no shapes of
+// its own; look up the deleted op instead.
+// Possible Errors:
+// - InvalidShapeSvgStringError for a batch that can't be decoded
+// - Any error of GetShape
+func GetShapes(op blockchain.Operation, canvasX int, canvasY int) ([]shapelib.Shape, error) {
+	if op.OpType != blockchain.BATCH {
+		shape, err := GetShape(op, canvasX, canvasY)
+		if err != nil {
+			return nil, err
+		}
+		return []shapelib.Shape{shape}, nil
+	}
+
+	members, err := blockchain.BatchShapes(op)
+	if err != nil {
+		return nil, libminer.InvalidShapeSvgStringError(op.SVGString)
+	}
+
+	shapes := make([]shapelib.Shape, len(members))
+	for i, member := range members {
+		shape, err := GetShape(member, canvasX, canvasY)
+		if err != nil {
+			return nil, err
+		}
+		shapes[i] = shape
+	}
+	return shapes, nil
+}
+
 func ComputeHash(data []byte) []byte {
 	h := md5.New()
 	h.Write(data)